@@ -0,0 +1,1001 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/internal/db"
+	"github.com/sauerbraten/maitred/v2/pkg/auth"
+)
+
+// The command router in this file knows nothing about Discord's API: frontends (see frontend.go) translate slash
+// commands and text messages into commands, and send back the reply returned by Server.handleCommand.
+
+// user is a chat user. ID is immutable; the other fields are only used for display and to find accounts registered
+// before IDs were stored.
+type user struct {
+	ID            string
+	Username      string
+	Discriminator string
+}
+
+func (u user) String() string {
+	if u.Discriminator == "" || u.Discriminator == "0" {
+		return u.Username
+	}
+	return u.Username + "#" + u.Discriminator
+}
+
+func (u user) mention() string {
+	return "<@" + u.ID + ">"
+}
+
+// defaultAuthName is the auth name a user gets when registering without choosing one. Users who still have a
+// discriminator keep it, as accounts have been named like that before discriminators were retired.
+func (u user) defaultAuthName() string {
+	if u.Discriminator == "" || u.Discriminator == "0" {
+		return removeWhitespace(u.Username)
+	}
+	return removeWhitespace(u.Username) + "#" + u.Discriminator
+}
+
+// legacyNames are the names an account or ban of u could have been stored under before IDs were stored. Users
+// without a discriminator were stored as name#0.
+func (u user) legacyNames() []string {
+	discriminator := u.Discriminator
+	if discriminator == "" {
+		discriminator = "0"
+	}
+	legacy := u.Username + "#" + discriminator
+	return []string{removeWhitespace(legacy), legacy}
+}
+
+type optionType int
+
+const (
+	optionString optionType = iota
+	optionUser
+)
+
+type option struct {
+	name        string
+	description string
+	typ         optionType
+	required    bool
+	rest        bool // in text messages, the option takes all remaining words
+	// in text messages, an optional option is left out if it doesn't accept the next word, which then goes to the
+	// following option; nil accepts any word
+	accepts func(string) bool
+}
+
+type commandSpec struct {
+	name        string
+	description string
+	options     []option   // required options first
+	permission  permission // required to run the command; runs of privileged commands are recorded in the audit log
+	hidden      bool       // not listed in help and not offered as slash command, e.g. because it's run by a button
+	run         func(s *Server, c *command) string
+}
+
+// usage returns the syntax of the command as used in text messages.
+func (spec *commandSpec) usage() string {
+	b := strings.Builder{}
+	b.WriteString(spec.name)
+	for _, opt := range spec.options {
+		name := opt.name
+		if opt.rest {
+			name += "..."
+		}
+		if opt.required {
+			fmt.Fprintf(&b, " <%s>", name)
+		} else {
+			fmt.Fprintf(&b, " [%s]", name)
+		}
+	}
+	return b.String()
+}
+
+// command is a single invocation of a command.
+type command struct {
+	name    string
+	author  user
+	args    map[string]string // string options by name
+	targets []user            // the user option, or all users mentioned in a text message
+}
+
+var commands []*commandSpec
+
+func init() {
+	// assigned in init() since the help command refers to the list itself
+	commands = []*commandSpec{
+		{
+			name:        "help",
+			description: "Lists all commands",
+			run:         (*Server).help,
+		},
+		{
+			name:        "register",
+			description: "Registers you using your public key",
+			options: []option{
+				{name: "pubkey", description: "your public key (output of `getpubkey`)", typ: optionString, required: true},
+				{name: "name", description: "the name to use on game servers (default: your Discord username)", typ: optionString},
+			},
+			run: (*Server).register,
+		},
+		{
+			name:        "override",
+			description: "Replaces your registered public key",
+			options: []option{
+				{name: "pubkey", description: "your new public key", typ: optionString, required: true},
+			},
+			run: (*Server).override,
+		},
+		{
+			name:        "whoami",
+			description: "Shows your account",
+			run:         (*Server).whoami,
+		},
+		{
+			name:        "unregister",
+			description: "Deletes your account and all your keys",
+			run:         (*Server).unregister,
+		},
+		{
+			name:        "rename",
+			description: "Changes the name you use on game servers",
+			options: []option{
+				{name: "name", description: "your new name", typ: optionString, required: true},
+			},
+			run: (*Server).rename,
+		},
+		{
+			name:        "notifications",
+			description: "Turns notifications about your account being used on new game servers on or off",
+			options: []option{
+				{name: "setting", description: "'on' or 'off'", typ: optionString, required: true},
+			},
+			run: (*Server).notifications,
+		},
+		{
+			name:        "wasntme",
+			description: "Suspends your account because someone else used it",
+			hidden:      true,
+			run:         (*Server).wasntMe,
+		},
+		{
+			name:        "keys",
+			description: "Lists your public keys",
+			run:         (*Server).keys,
+		},
+		{
+			name:        "addkey",
+			description: "Adds another public key (e.g. for a second computer)",
+			options: []option{
+				{name: "label", description: "a name for the key, e.g. 'laptop'", typ: optionString, required: true},
+				{name: "pubkey", description: "the public key", typ: optionString, required: true},
+			},
+			run: (*Server).addKeyCommand,
+		},
+		{
+			name:        "revokekey",
+			description: "Removes one of your public keys",
+			options: []option{
+				{name: "label", description: "the label of the key", typ: optionString, required: true},
+			},
+			run: (*Server).revokeKeyCommand,
+		},
+		{
+			name:        "replacekey",
+			description: "Replaces one of your public keys",
+			options: []option{
+				{name: "label", description: "the label of the key", typ: optionString, required: true},
+				{name: "pubkey", description: "the new public key", typ: optionString, required: true},
+				{name: "grace", description: "how long the old key keeps working, e.g. '7d'", typ: optionString},
+			},
+			run: (*Server).replaceKeyCommand,
+		},
+		{
+			name:        "ban",
+			description: "Deletes a user's account and prevents them from registering again",
+			options: []option{
+				{name: "user", description: "the user to ban", typ: optionUser, required: true},
+				{name: "duration", description: "how long the ban lasts, e.g. '7d' (default: forever)", typ: optionString, accepts: isDuration},
+				{name: "reason", description: "why the user is banned", typ: optionString, rest: true},
+			},
+			permission: permissionModerator,
+			run:        (*Server).ban,
+		},
+		{
+			name:        "suspend",
+			description: "Prevents a user from authenticating, without deleting their account",
+			options: []option{
+				{name: "user", description: "the user to suspend", typ: optionUser, required: true},
+				{name: "duration", description: "how long the suspension lasts, e.g. '7d' (default: forever)", typ: optionString, accepts: isDuration},
+				{name: "reason", description: "why the user is suspended", typ: optionString, rest: true},
+			},
+			permission: permissionModerator,
+			run:        (*Server).suspend,
+		},
+		{
+			name:        "bans",
+			description: "Lists all bans and suspensions",
+			permission:  permissionModerator,
+			run:         (*Server).bans,
+		},
+		{
+			name:        "unban",
+			description: "Allows a banned user to register again",
+			options: []option{
+				{name: "user", description: "the user to unban", typ: optionUser, required: true},
+			},
+			permission: permissionAdmin,
+			run:        (*Server).unban,
+		},
+		{
+			name:        "gban",
+			description: "Bans an IP address or range from all game servers",
+			options: []option{
+				{name: "ip", description: "an IP address, a range like '1.2.3.0/24', or a partial IPv4 address like '1.2.3'", typ: optionString, required: true},
+				{name: "duration", description: "how long the ban lasts, e.g. '7d' (default: forever)", typ: optionString, accepts: isDuration},
+				{name: "reason", description: "why the IP range is banned", typ: optionString, rest: true},
+			},
+			permission: permissionAdmin,
+			run:        (*Server).gban,
+		},
+		{
+			name:        "gbans",
+			description: "Lists the IP ranges banned from all game servers",
+			permission:  permissionAdmin,
+			run:         (*Server).gbans,
+		},
+		{
+			name:        "ungban",
+			description: "Lifts a ban of an IP address or range",
+			options: []option{
+				{name: "ip", description: "the banned IP address or range", typ: optionString, required: true},
+			},
+			permission: permissionAdmin,
+			run:        (*Server).ungban,
+		},
+		{
+			name:        "audit",
+			description: "Shows who recently ran moderator and admin commands",
+			permission:  permissionAdmin,
+			run:         (*Server).auditLog,
+		},
+	}
+}
+
+func lookupCommand(name string) *commandSpec {
+	for _, spec := range commands {
+		if spec.name == name {
+			return spec
+		}
+	}
+	return nil
+}
+
+// textAliases maps the first words of text messages in the syntax used before slash commands existed to commands.
+var textAliases = map[string]string{
+	"key add":     "addkey",
+	"key revoke":  "revokekey",
+	"key replace": "replacekey",
+}
+
+var errUnknownCommand = errors.New("unknown command")
+
+// parseTextCommand parses a text message into a command. Options are expected in the order they are declared in, user
+// options are taken from mentions. A single word that is not a command is treated as a public key to register with. An
+// empty message results in a nil command.
+func parseTextCommand(author user, content string, mentions []user) (*command, error) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	name := strings.ToLower(fields[0])
+	if len(fields) >= 2 {
+		if alias, ok := textAliases[name+" "+strings.ToLower(fields[1])]; ok {
+			name, fields = alias, fields[1:]
+		}
+	}
+
+	spec := lookupCommand(name)
+	if spec == nil {
+		if len(fields) == 1 {
+			// most likely a public key (or something the user mistook for one, which the reply will explain)
+			return &command{name: "register", author: author, args: map[string]string{"pubkey": fields[0]}}, nil
+		}
+		return nil, errUnknownCommand
+	}
+
+	c := &command{name: spec.name, author: author, args: map[string]string{}}
+	args := fields[1:]
+	for _, opt := range spec.options {
+		switch {
+		case opt.typ == optionUser:
+			// mentions show up as words like <@123> in the content
+			for len(args) > 0 && strings.HasPrefix(args[0], "<@") && strings.HasSuffix(args[0], ">") {
+				args = args[1:]
+			}
+			c.targets = mentions
+			if opt.required && len(mentions) == 0 {
+				return nil, fmt.Errorf("usage: `%s`", spec.usage())
+			}
+		case len(args) == 0:
+			if opt.required {
+				return nil, fmt.Errorf("usage: `%s`", spec.usage())
+			}
+		case !opt.required && opt.accepts != nil && !opt.accepts(args[0]):
+			continue
+		case opt.rest:
+			c.args[opt.name], args = strings.Join(args, " "), nil
+		default:
+			c.args[opt.name], args = args[0], args[1:]
+		}
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("usage: `%s`", spec.usage())
+	}
+
+	return c, nil
+}
+
+// handleCommand runs c and returns the reply for its author.
+func (s *Server) handleCommand(c *command) string {
+	spec := lookupCommand(c.name)
+	if spec == nil {
+		return "I don't know that command. Try `help`."
+	}
+
+	s.claimLegacyAccount(c.author)
+	for _, u := range c.targets {
+		s.claimLegacyAccount(u)
+	}
+
+	if spec.permission > permissionNone {
+		perm, err := s.perms.of(c.author.ID)
+		if err != nil {
+			log.Printf("checking permission of %s to run %s: %v", c.author, c.name, err)
+			return "That didn't work! :thinking: I can't tell if you are allowed to do that."
+		}
+		if perm < spec.permission {
+			return "You are not allowed to do that. :no_entry:"
+		}
+		s.audit(c)
+	}
+
+	return spec.run(s, c)
+}
+
+// audit records that c was run.
+func (s *Server) audit(c *command) {
+	args := []string{}
+	for _, opt := range lookupCommand(c.name).options {
+		if v, ok := c.args[opt.name]; ok {
+			args = append(args, opt.name+"="+v)
+		}
+	}
+	for _, target := range c.targets {
+		args = append(args, fmt.Sprintf("user=%s (%s)", target, target.ID))
+	}
+	err := s.db.AddAuditEntry(c.author.ID, c.author.String(), c.name, strings.Join(args, " "))
+	if err != nil {
+		log.Printf("recording %s by %s in audit log: %v", c.name, c.author, err)
+	}
+}
+
+func (s *Server) help(c *command) string {
+	b := strings.Builder{}
+	b.WriteString("Commands (use them as slash commands, or send them to me as a message):\n")
+	perm, err := s.perms.of(c.author.ID)
+	if err != nil {
+		log.Printf("checking permission of %s: %v", c.author, err)
+	}
+	for _, spec := range commands {
+		if spec.permission > perm || spec.hidden {
+			continue
+		}
+		fmt.Fprintf(&b, "`%s`: %s\n", spec.usage(), spec.description)
+	}
+	return b.String()
+}
+
+func (s *Server) register(c *command) string {
+	return s.registerUser(c, false)
+}
+
+func (s *Server) override(c *command) string {
+	return s.registerUser(c, true)
+}
+
+func (s *Server) registerUser(c *command, override bool) string {
+	ban, err := s.getBan(c.author.ID)
+	if err != nil {
+		log.Printf("discord: checking if %s is banned: %v", c.author, err)
+		return "That didn't work! :thinking: I can't tell if you are banned or not."
+	}
+	// users who suspended themselves because their key was used by someone else can lift the suspension by replacing
+	// the key
+	selfSuspended := ban != nil && ban.Suspension && ban.IssuerID == c.author.ID
+	if ban != nil && !(selfSuspended && override) {
+		return describeBan(ban) + " :no_entry:"
+	}
+
+	pubkey := c.args["pubkey"]
+	if selfSuspended && s.hasKey(c.author.ID, pubkey) {
+		return "You need to generate a new key pair, the old key can't be trusted anymore. " + registrationHelp(c.author.defaultAuthName())
+	}
+	name, ok := c.args["name"]
+	if !ok {
+		name = c.author.defaultAuthName()
+	}
+
+	name, err = s.addUser(c.author.ID, name, pubkey, override)
+	if err != nil {
+		var keyErr *auth.KeyError
+		if existsErr := new(db.UserExistsError); errors.As(err, existsErr) {
+			return fmt.Sprintf("You are already registered as **%s** (your public key is: %s).\nTo replace your registered public key, use `override %s`.", existsErr.Name, existsErr.PublicKey, pubkey)
+		} else if errors.As(err, &keyErr) {
+			log.Printf("discord: %s sent invalid public key: %v\n", c.author, err)
+			return fmt.Sprintf("That's not a valid public key: %s. :face_with_monocle:\n%s", keyErrorHint(keyErr), registrationHelp(c.author.defaultAuthName()))
+		} else if errors.Is(err, errInvalidAuthName) {
+			return fmt.Sprintf("You can't use that name (%v).\nTo choose a different name, use `register <pubkey> <name>`.", err)
+		} else if taken := nameTaken(err); taken != nil {
+			return fmt.Sprintf("That name is too similar to **%s**, which is already taken. :confused:\nTo choose a different name, use `register <pubkey> <name>`.", string(*taken))
+		}
+		log.Printf("discord: adding user %s: %v\n", c.author, err)
+		return "That didn't work! :dizzy_face: " + registrationHelp(c.author.defaultAuthName())
+	}
+
+	log.Printf("discord: %s (%s) registered as %s using public key %s\n", c.author, c.author.ID, name, pubkey)
+
+	if selfSuspended {
+		// any of the other keys could be the one that was used by someone else
+		revoked, err := s.revokeOtherKeys(name)
+		if err != nil {
+			log.Printf("discord: revoking other keys of %s: %v\n", c.author, err)
+			return "Your key was replaced, but your account is still suspended. :dizzy_face: Please contact a moderator."
+		}
+		err = s.unbanUser(c.author.ID)
+		if err != nil {
+			log.Printf("discord: lifting suspension of %s: %v\n", c.author, err)
+			return "Your key was replaced, but your account is still suspended. :dizzy_face: Please contact a moderator."
+		}
+		log.Printf("discord: %s (%s) lifted their suspension, revoking %d other key(s)\n", c.author, c.author.ID, revoked)
+		reply := fmt.Sprintf("Your key was replaced and your account is active again, **%s**!", name)
+		if revoked > 0 {
+			reply += fmt.Sprintf(" Your %d other key(s) were revoked, add the ones you still need again with `addkey`.", revoked)
+		}
+		return reply
+	}
+
+	return fmt.Sprintf("registered you as **%s**!", name)
+}
+
+// hasKey reports whether the account of the user with the given ID has pubkey as one of its keys.
+func (s *Server) hasKey(discordID, pubkey string) bool {
+	name, err := s.userName(discordID)
+	if err != nil {
+		return false
+	}
+	keys, err := s.getKeys(name)
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if strings.EqualFold(k.PublicKey, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) notifications(c *command) string {
+	var notify bool
+	switch strings.ToLower(c.args["setting"]) {
+	case "on":
+		notify = true
+	case "off":
+		notify = false
+	default:
+		return "Usage: `notifications <on|off>`"
+	}
+	err := s.setNotifyNewServers(c.author.ID, notify)
+	if err != nil {
+		if errors.As(err, new(db.UserNotFoundError)) {
+			return unregisteredReply(c, err)
+		}
+		log.Printf("discord: changing notification setting of %s: %v\n", c.author, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	if notify {
+		return ":white_check_mark: I'll let you know when your account is used on a game server for the first time."
+	}
+	return ":white_check_mark: I won't notify you about new game servers anymore."
+}
+
+// wasntMe suspends the author's account after they reported that someone else used it.
+func (s *Server) wasntMe(c *command) string {
+	_, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	ban, err := s.getBan(c.author.ID)
+	if err != nil {
+		log.Printf("discord: checking if %s is banned: %v\n", c.author, err)
+		return "That didn't work! :dizzy_face: Please contact a moderator."
+	}
+	if ban != nil {
+		// don't replace a suspension by a moderator with one the user can lift
+		return describeBan(ban) + " Nobody can log in with your account right now."
+	}
+	reason := "login on a new server was reported as not legitimate"
+	err = s.banUser(c.author, c.author, reason, 0, true)
+	if err != nil {
+		log.Printf("discord: suspending %s: %v\n", c.author, err)
+		return "That didn't work! :dizzy_face: Please contact a moderator."
+	}
+	log.Printf("discord: %s (%s) suspended their account: %s\n", c.author, c.author.ID, reason)
+	s.kickAccount(c.author.ID, "account suspended by its owner")
+	return ":lock: Your account is suspended, nobody can log in with it now.\n" +
+		"Someone else probably has your private key. Generate a new key pair (see step 1 below) and use `override <new pubkey>` to replace it and lift the suspension.\n" +
+		registrationHelp(c.author.defaultAuthName())
+}
+
+func (s *Server) whoami(c *command) string {
+	name, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	keys, err := s.getKeys(name)
+	if err != nil && !errors.As(err, new(db.UserNotFoundError)) {
+		log.Printf("discord: listing keys of %s: %v\n", name, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	reply := fmt.Sprintf("You are registered as **%s** with %d key(s).", name, len(keys))
+	ban, err := s.getBan(c.author.ID)
+	if err != nil {
+		log.Printf("discord: checking if %s is banned: %v", c.author, err)
+	} else if ban != nil {
+		reply += "\n" + describeBan(ban)
+	}
+	return reply
+}
+
+// describeBan tells a user about their ban or suspension.
+func describeBan(ban *db.Ban) string {
+	desc := "You are banned"
+	if ban.Suspension {
+		desc = "Your account is suspended"
+	}
+	if ban.ExpiresAt.Valid {
+		desc += " until " + time.Unix(ban.ExpiresAt.Int64, 0).UTC().Format(time.DateTime)
+	}
+	if ban.Reason != "" {
+		desc += " (" + ban.Reason + ")"
+	}
+	return desc + "."
+}
+
+func (s *Server) unregister(c *command) string {
+	_, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	err = s.delUser(c.author.ID)
+	if err != nil {
+		log.Printf("discord: deleting %s: %v\n", c.author, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	log.Printf("discord: %s (%s) unregistered\n", c.author, c.author.ID)
+	return ":white_check_mark: Your account and all your keys were deleted."
+}
+
+func (s *Server) rename(c *command) string {
+	newName := c.args["name"]
+	err := s.renameUser(c.author.ID, newName)
+	if err != nil {
+		switch {
+		case errors.As(err, new(db.UserNotFoundError)):
+			return unregisteredReply(c, err)
+		case errors.Is(err, errInvalidAuthName):
+			return fmt.Sprintf("You can't use that name (%v).", err)
+		case nameTaken(err) != nil:
+			return fmt.Sprintf("That name is too similar to **%s**, which is already taken. :confused:", string(*nameTaken(err)))
+		default:
+			log.Printf("discord: renaming %s to %s: %v\n", c.author, newName, err)
+			return "That didn't work! :dizzy_face:"
+		}
+	}
+	log.Printf("discord: %s (%s) renamed to %s\n", c.author, c.author.ID, newName)
+	return fmt.Sprintf("You are now **%s**! Don't forget to change the name in your `auth.cfg` as well.", newName)
+}
+
+func (s *Server) keys(c *command) string {
+	name, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	keys, err := s.getKeys(name)
+	if err != nil {
+		if errors.As(err, new(db.UserNotFoundError)) {
+			return "You don't have any keys."
+		}
+		log.Printf("discord: listing keys of %s: %v\n", name, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	return formatKeys(keys)
+}
+
+func (s *Server) addKeyCommand(c *command) string {
+	name, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	return keyCommandReply(c, name, s.addKey(name, c.args["label"], c.args["pubkey"]))
+}
+
+func (s *Server) revokeKeyCommand(c *command) string {
+	name, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	return keyCommandReply(c, name, s.revokeKey(name, c.args["label"]))
+}
+
+func (s *Server) replaceKeyCommand(c *command) string {
+	name, err := s.userName(c.author.ID)
+	if err != nil {
+		return unregisteredReply(c, err)
+	}
+	var grace time.Duration
+	if g, ok := c.args["grace"]; ok {
+		grace, err = parseDuration(g)
+		if err != nil {
+			return fmt.Sprintf("I don't understand the grace period `%s`: %v", g, err)
+		}
+	}
+	return keyCommandReply(c, name, s.replaceKey(name, c.args["label"], c.args["pubkey"], grace))
+}
+
+func keyCommandReply(c *command, name string, err error) string {
+	label := c.args["label"]
+	if err != nil {
+		var keyErr *auth.KeyError
+		switch {
+		case errors.As(err, new(db.KeyExistsError)):
+			return fmt.Sprintf("You already have a key labelled `%s`. Use `replacekey` to replace it.", label)
+		case errors.As(err, new(db.KeyNotFoundError)):
+			return fmt.Sprintf("You don't have a key labelled `%s`.", label)
+		case errors.As(err, &keyErr):
+			return fmt.Sprintf("That's not a valid public key: %s. :face_with_monocle:", keyErrorHint(keyErr))
+		default:
+			log.Printf("discord: %s: %s %s: %v\n", name, c.name, label, err)
+			return fmt.Sprintf("That didn't work! :dizzy_face: %v", err)
+		}
+	}
+	log.Printf("discord: %s: %s %s\n", name, c.name, label)
+	return ":white_check_mark: done"
+}
+
+func (s *Server) ban(c *command) string {
+	return s.banOrSuspend(c, false)
+}
+
+func (s *Server) suspend(c *command) string {
+	return s.banOrSuspend(c, true)
+}
+
+// banOrSuspend bans the targets of c, deleting their accounts, or suspends them, keeping their accounts.
+func (s *Server) banOrSuspend(c *command, suspension bool) string {
+	verb := "banned"
+	if suspension {
+		verb = "suspended"
+	}
+
+	reason := c.args["reason"]
+	var duration time.Duration
+	if d, ok := c.args["duration"]; ok {
+		var err error
+		duration, err = parseDuration(d)
+		if err != nil {
+			return invalidDurationReply(err)
+		}
+	}
+
+	replies := []string{}
+	for _, target := range c.targets {
+		ban, err := s.getBan(target.ID)
+		if err != nil {
+			replies = append(replies, fmt.Sprintf(":boom: checking if %s is banned: %v", target.mention(), err))
+			continue
+		}
+		if ban != nil && ban.IssuerID != "" && ban.IssuerID != c.author.ID && ban.IssuerID != target.ID {
+			replies = append(replies, alreadyBannedReply(target, ban))
+			continue
+		}
+		if !suspension {
+			err := s.delUser(target.ID)
+			if err != nil {
+				replies = append(replies, fmt.Sprintf(":boom: deleting %s: %v", target.mention(), err))
+				continue
+			}
+		}
+		err = s.banUser(target, c.author, reason, duration, suspension)
+		if existsErr := new(db.BanExistsError); errors.As(err, existsErr) {
+			replies = append(replies, alreadyBannedReply(target, (*db.Ban)(existsErr)))
+			continue
+		}
+		if err != nil {
+			replies = append(replies, fmt.Sprintf(":boom: banning %s: %v", target.mention(), err))
+			continue
+		}
+		log.Printf("discord: %s %s %s (%s) for %v: %s\n", c.author, verb, target, target.ID, duration, reason)
+		reply := fmt.Sprintf(":white_check_mark: %s %s %s", verb, target.mention(), formatBanTerms(duration, reason))
+		if n := s.kickAccount(target.ID, reason); n > 0 {
+			reply += fmt.Sprintf(", kicking them from %d game server(s)", n)
+		}
+		replies = append(replies, reply)
+	}
+	return strings.Join(replies, "\n")
+}
+
+func alreadyBannedReply(target user, ban *db.Ban) string {
+	verb := "banned"
+	if ban.Suspension {
+		verb = "suspended"
+	}
+	return fmt.Sprintf(":x: %s is already %s by <@%s>. Use `unban` first to change the terms.", target.mention(), verb, ban.IssuerID)
+}
+
+// formatBanTerms describes how long a ban lasts and why.
+func formatBanTerms(duration time.Duration, reason string) string {
+	terms := "permanently"
+	if duration > 0 {
+		terms = "until " + time.Now().Add(duration).UTC().Format(time.DateTime)
+	}
+	if reason != "" {
+		terms += " (" + reason + ")"
+	}
+	return terms
+}
+
+func (s *Server) bans(c *command) string {
+	bans, err := s.getBans()
+	if err != nil {
+		log.Printf("discord: listing bans: %v\n", err)
+		return "That didn't work! :dizzy_face:"
+	}
+	if len(bans) == 0 {
+		return "Nobody is banned. :innocent:"
+	}
+	b := strings.Builder{}
+	for _, ban := range bans {
+		if ban.DiscordID.Valid {
+			fmt.Fprintf(&b, "<@%s> (%s)", ban.DiscordID.String, ban.Name)
+		} else {
+			b.WriteString(ban.Name)
+		}
+		if ban.Suspension {
+			b.WriteString(": suspended")
+		} else {
+			b.WriteString(": banned")
+		}
+		if ban.ExpiresAt.Valid {
+			fmt.Fprintf(&b, " until %s", time.Unix(ban.ExpiresAt.Int64, 0).UTC().Format(time.DateTime))
+		}
+		if ban.IssuerID != "" {
+			fmt.Fprintf(&b, " by <@%s>", ban.IssuerID)
+		}
+		if ban.Reason != "" {
+			fmt.Fprintf(&b, ": %s", ban.Reason)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (s *Server) unban(c *command) string {
+	replies := []string{}
+	for _, target := range c.targets {
+		err := s.unbanUser(target.ID)
+		if err != nil {
+			replies = append(replies, fmt.Sprintf(":boom: unbanning %s: %v", target.mention(), err))
+			continue
+		}
+		log.Printf("discord: %s unbanned %s (%s)\n", c.author, target, target.ID)
+		replies = append(replies, fmt.Sprintf(":white_check_mark: unbanned %s", target.mention()))
+	}
+	return strings.Join(replies, "\n")
+}
+
+func (s *Server) gban(c *command) string {
+	prefix, err := parseIPRange(c.args["ip"])
+	if err != nil {
+		return fmt.Sprintf(":x: %v", err)
+	}
+
+	reason := c.args["reason"]
+	var duration time.Duration
+	if d, ok := c.args["duration"]; ok {
+		duration, err = parseDuration(d)
+		if err != nil {
+			return invalidDurationReply(err)
+		}
+	}
+
+	err = s.addGBan(prefix, c.author, reason, duration)
+	if err != nil {
+		log.Printf("discord: adding gban of %s: %v\n", prefix, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	log.Printf("discord: %s gbanned %s for %v: %s\n", c.author, prefix, duration, reason)
+	reply := fmt.Sprintf(":white_check_mark: banned %s %s", prefix, formatBanTerms(duration, reason))
+	if _, ok := gbanArg(prefix); !ok {
+		reply += "\nNote: game servers only support IPv4 bans, so this ban isn't sent to them."
+	}
+	return reply
+}
+
+func (s *Server) gbans(c *command) string {
+	gbans, err := s.getGBans()
+	if err != nil {
+		log.Printf("discord: listing gbans: %v\n", err)
+		return "That didn't work! :dizzy_face:"
+	}
+	if len(gbans) == 0 {
+		return "No IP ranges are banned. :innocent:"
+	}
+	b := strings.Builder{}
+	for _, gban := range gbans {
+		b.WriteString(gban.Prefix)
+		if gban.ExpiresAt.Valid {
+			fmt.Fprintf(&b, " until %s", time.Unix(gban.ExpiresAt.Int64, 0).UTC().Format(time.DateTime))
+		}
+		if gban.IssuerID != "" {
+			fmt.Fprintf(&b, " by <@%s>", gban.IssuerID)
+		}
+		if gban.Reason != "" {
+			fmt.Fprintf(&b, ": %s", gban.Reason)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (s *Server) ungban(c *command) string {
+	prefix, err := parseIPRange(c.args["ip"])
+	if err != nil {
+		return fmt.Sprintf(":x: %v", err)
+	}
+	err = s.delGBan(prefix)
+	if errors.As(err, new(db.GBanNotFoundError)) {
+		return fmt.Sprintf("%s is not banned. Use `gbans` to see all banned IP ranges.", prefix)
+	}
+	if err != nil {
+		log.Printf("discord: removing gban of %s: %v\n", prefix, err)
+		return "That didn't work! :dizzy_face:"
+	}
+	log.Printf("discord: %s removed gban of %s\n", c.author, prefix)
+	return fmt.Sprintf(":white_check_mark: unbanned %s", prefix)
+}
+
+func (s *Server) auditLog(c *command) string {
+	entries, err := s.db.GetAuditLog(20)
+	if err != nil {
+		log.Printf("discord: retrieving audit log: %v\n", err)
+		return "That didn't work! :dizzy_face:"
+	}
+	if len(entries) == 0 {
+		return "Nobody ran any privileged commands yet."
+	}
+	b := strings.Builder{}
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s: <@%s> ran `%s` %s\n", time.Unix(e.CreatedAt, 0).UTC().Format(time.DateTime), e.ActorID, e.Command, e.Args)
+	}
+	return b.String()
+}
+
+func unregisteredReply(c *command, err error) string {
+	if errors.As(err, new(db.UserNotFoundError)) {
+		return "You are not registered. " + registrationHelp(c.author.defaultAuthName())
+	}
+	log.Printf("discord: looking up account of %s: %v\n", c.author, err)
+	return "That didn't work! :dizzy_face:"
+}
+
+// nameTaken returns the NameTakenError in err's chain, or nil.
+func nameTaken(err error) *db.NameTakenError {
+	taken := new(db.NameTakenError)
+	if errors.As(err, taken) {
+		return taken
+	}
+	return nil
+}
+
+func formatKeys(keys []db.Key) string {
+	var b strings.Builder
+	b.WriteString("Your keys:\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "- `%s`: %s", k.Label, k.PublicKey)
+		if k.ExpiresAt.Valid {
+			fmt.Fprintf(&b, " (replaced, works until %s)", time.Unix(k.ExpiresAt.Int64, 0).UTC().Format(time.DateTime))
+		}
+		if k.LastAuthedAt > 0 {
+			fmt.Fprintf(&b, ", last used %s", time.Unix(k.LastAuthedAt, 0).UTC().Format(time.DateTime))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func registrationHelp(name string) string {
+	return fmt.Sprintf("To register, follow these steps:\n 1. in Sauerbraten, run `/authkey \"%s\" (genauthkey (rndstr 32)) p1x.pw; saveauthkeys; echo (getpubkey p1x.pw)`\n 2. use `/register` with the last line of output, or send it to me here (it's easiest to copy this from the command line window)\nTo use a different name on game servers, replace %s with the name you want in step 1 and use `register <pubkey> <name>` in step 2 instead.\n", name, name)
+}
+
+// keyErrorHint explains to a user what is wrong with the public key they sent.
+func keyErrorHint(err *auth.KeyError) string {
+	switch {
+	case errors.Is(err, auth.ErrKeyEmpty):
+		return "you didn't send me anything"
+	case errors.Is(err, auth.ErrKeyIsPrivate):
+		return "that's your **private** key! Keep it secret and send me the public key instead (you should generate a new key pair now, to be safe)"
+	case errors.Is(err, auth.ErrKeyPrefix):
+		return "public keys start with `+` or `-`"
+	case errors.Is(err, auth.ErrKeyNotHex):
+		return "after the `+` or `-`, a public key only contains the digits 0-9 and the letters a-f"
+	case errors.Is(err, auth.ErrKeyOutOfRange):
+		return "it's too long, maybe you copied more than one line?"
+	case errors.Is(err, auth.ErrKeyNotOnCurve), errors.Is(err, auth.ErrKeyInfinity):
+		return "it looks like a key, but it's not one (maybe you made a typo?)"
+	case errors.Is(err, auth.ErrKeyWeak):
+		return "your private key is too easy to guess, please generate a new one"
+	default:
+		return err.Err.Error()
+	}
+}
+
+func invalidDurationReply(err error) string {
+	return fmt.Sprintf(":x: That's not a valid duration (%v). Use something like `7d` or `12h`.", err)
+}
+
+func isDuration(s string) bool {
+	_, err := parseDuration(s)
+	return err == nil
+}
+
+// parseDuration is like time.ParseDuration, but also accepts whole days, e.g. "7d".
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days: %s", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration: %s", s)
+	}
+	return d, nil
+}
+
+func removeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// textCommandError returns the reply to a text message parseTextCommand could not parse.
+func textCommandError(err error) string {
+	if errors.Is(err, errUnknownCommand) {
+		return "I don't know that command. Try `help`."
+	}
+	return err.Error()
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/cmd/discordauth/config"
+	"github.com/sauerbraten/maitred/v2/internal/db"
+	"github.com/sauerbraten/maitred/v2/pkg/auth"
+)
+
+var (
+	admin = user{ID: "1", Username: "admin"}
+	alice = user{ID: "2", Username: "alice"}
+	bob   = user{ID: "3", Username: "bob", Discriminator: "1234"}
+	mod   = user{ID: "4", Username: "mod"}
+
+	longName = user{ID: "5", Username: strings.Repeat("x", 32), Discriminator: "4321"}
+)
+
+var testRoles = map[string][]string{
+	admin.ID: {"10", "11"},
+	alice.ID: {"12"},
+	mod.ID:   {"11"},
+}
+
+// newTestServer returns a server backed by a fresh database, with admin as the only admin and mod as the only
+// moderator, and a fake frontend connected to it.
+func newTestServer(t *testing.T) (*Server, *fakeFrontend) {
+	t.Helper()
+
+	t.Chdir("../..") // for the migrations directory
+	d, err := db.New(filepath.Join(t.TempDir(), "users.sqlite"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	perms := newPermissions(&fakeRoles{roles: testRoles}, "guild", map[string]struct{}{"10": {}}, map[string]struct{}{"11": {}}, time.Minute)
+	s := newServer(nil, d, perms, nil, newGameServers(time.Hour), stop)
+	f := &fakeFrontend{}
+	err = s.serve(f)
+	if err != nil {
+		t.Fatalf("starting frontend: %v", err)
+	}
+	return s, f
+}
+
+func newPublicKey(t *testing.T) string {
+	t.Helper()
+	_, pub, err := auth.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+	return pub.String()
+}
+
+func TestCommands(t *testing.T) {
+	type step struct {
+		from     user
+		msg      string // sent as text message; %key1 and %key2 are replaced with public keys
+		mentions []user
+		reply    string // expected to be contained in the only reply; empty means no reply
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "register",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you as **alice**"},
+				{from: alice, msg: "whoami", reply: "registered as **alice** with 1 key(s)"},
+				{from: bob, msg: "register %key2 bobby", reply: "registered you as **bobby**"},
+			},
+		},
+		{
+			name: "invalid key",
+			steps: []step{
+				{from: alice, msg: "+xyz", reply: "not a valid public key"},
+				{from: alice, msg: "whoami", reply: "not registered"},
+			},
+		},
+		{
+			name: "already registered",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: alice, msg: "%key2", reply: "already registered as **alice**"},
+				{from: alice, msg: "register %key2 other", reply: "already registered as **alice**"},
+			},
+		},
+		{
+			name: "override",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: alice, msg: "override %key2", reply: "registered you as **alice**"},
+				{from: alice, msg: "keys", reply: "%key2"},
+			},
+		},
+		{
+			name: "name taken",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: bob, msg: "register %key2 AL1CE", reply: "too similar to **alice**"},
+			},
+		},
+		{
+			name: "rename",
+			steps: []step{
+				{from: alice, msg: "rename ali", reply: "not registered"},
+				{from: alice, msg: "%key1", reply: "registered you as **alice**"},
+				{from: alice, msg: "rename a^b", reply: "can't use that name"},
+				{from: alice, msg: "rename ali", reply: "You are now **ali**"},
+				{from: alice, msg: "whoami", reply: "registered as **ali**"},
+				{from: bob, msg: "%key2", reply: "registered you as **bob#1234**"},
+				{from: bob, msg: "rename AL1", reply: "too similar to **ali**"},
+				{from: alice, msg: "rename Ali", reply: "You are now **Ali**"},
+			},
+		},
+		{
+			name: "long Discord name",
+			steps: []step{
+				{from: longName, msg: "%key1", reply: "registered you as **" + longName.Username + "#4321**"},
+			},
+		},
+		{
+			name: "banned user",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: admin, msg: "ban <@2>", mentions: []user{alice}, reply: "banned <@2>"},
+				{from: alice, msg: "whoami", reply: "not registered"},
+				{from: alice, msg: "%key1", reply: "You are banned"},
+			},
+		},
+		{
+			name: "admin ban and unban",
+			steps: []step{
+				{from: alice, msg: "ban <@3>", mentions: []user{bob}, reply: "not allowed"},
+				{from: admin, msg: "ban <@3>", mentions: []user{bob}, reply: "banned <@3>"},
+				{from: bob, msg: "%key1", reply: "You are banned"},
+				{from: alice, msg: "unban <@3>", mentions: []user{bob}, reply: "not allowed"},
+				{from: admin, msg: "unban <@3>", mentions: []user{bob}, reply: "unbanned <@3>"},
+				{from: bob, msg: "%key1", reply: "registered you as **bob#1234**"},
+			},
+		},
+		{
+			name: "temporary ban",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: mod, msg: "ban <@2> 7d spamming the chat", mentions: []user{alice}, reply: "banned <@2> until"},
+				{from: alice, msg: "%key1", reply: "(spamming the chat)"},
+				{from: mod, msg: "bans", reply: "<@2> (alice): banned until"},
+				{from: mod, msg: "ban <@3> cheating", mentions: []user{bob}, reply: "banned <@3> permanently (cheating)"},
+				{from: mod, msg: "bans", reply: "<@3> (bob#1234): banned by <@4>: cheating"},
+			},
+		},
+		{
+			name: "suspension",
+			steps: []step{
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: mod, msg: "suspend <@2> 1d", mentions: []user{alice}, reply: "suspended <@2> until"},
+				{from: alice, msg: "whoami", reply: "Your account is suspended until"},
+				{from: alice, msg: "override %key2", reply: "Your account is suspended"},
+				{from: admin, msg: "unban <@2>", mentions: []user{alice}, reply: "unbanned <@2>"},
+				{from: alice, msg: "whoami", reply: "registered as **alice** with 1 key(s)."},
+			},
+		},
+		{
+			name: "notification settings",
+			steps: []step{
+				{from: alice, msg: "notifications off", reply: "not registered"},
+				{from: alice, msg: "%key1", reply: "registered you"},
+				{from: alice, msg: "notifications maybe", reply: "Usage: `notifications <on|off>`"},
+				{from: alice, msg: "notifications off", reply: "won't notify you"},
+				{from: alice, msg: "notifications on", reply: "let you know"},
+			},
+		},
+		{
+			name: "moderator",
+			steps: []step{
+				{from: mod, msg: "ban <@3>", mentions: []user{bob}, reply: "banned <@3>"},
+				{from: mod, msg: "unban <@3>", mentions: []user{bob}, reply: "not allowed"},
+				{from: mod, msg: "audit", reply: "not allowed"},
+				{from: admin, msg: "audit", reply: "<@4> ran `ban` user=bob#1234 (3)"},
+			},
+		},
+		{
+			name: "gbans",
+			steps: []step{
+				{from: mod, msg: "gban 1.2.3.4", reply: "not allowed"},
+				{from: admin, msg: "gban 1.2.3.4.5", reply: "invalid IP range"},
+				{from: admin, msg: "gban 1.2.3 7d aimbot", reply: "banned 1.2.3.0/24 until"},
+				{from: admin, msg: "gban 2001:db8::/32 spam", reply: "only support IPv4"},
+				{from: admin, msg: "gbans", reply: "1.2.3.0/24 until"},
+				{from: admin, msg: "ungban 5.6.7.8", reply: "5.6.7.8/32 is not banned"},
+				{from: admin, msg: "ungban 1.2.3.0/24", reply: "unbanned 1.2.3.0/24"},
+				{from: admin, msg: "gbans", reply: "2001:db8::/32 by <@1>: spam"},
+			},
+		},
+		{
+			name: "help",
+			steps: []step{
+				{from: alice, msg: "help", reply: "`register <pubkey> [name]`"},
+				{from: mod, msg: "help", reply: "`ban <user> [duration] [reason...]`"},
+				{from: admin, msg: "help", reply: "`audit`"},
+			},
+		},
+		{
+			name: "malformed messages",
+			steps: []step{
+				{from: alice, msg: ""},
+				{from: alice, msg: "  "},
+				{from: alice, msg: "what is this", reply: "I don't know that command"},
+				{from: admin, msg: "ban", reply: "usage: `ban <user> [duration] [reason...]`"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, f := newTestServer(t)
+			key1, key2 := newPublicKey(t), newPublicKey(t)
+			expand := strings.NewReplacer("%key1", key1, "%key2", key2).Replace
+
+			for i, step := range test.steps {
+				replies := f.send(step.from, expand(step.msg), step.mentions...)
+				if step.reply == "" {
+					if len(replies) != 0 {
+						t.Errorf("step %d ('%s'): expected no reply, got %q", i, step.msg, replies)
+					}
+					continue
+				}
+				if len(replies) != 1 || !strings.Contains(replies[0], expand(step.reply)) {
+					t.Errorf("step %d ('%s'): expected reply containing '%s', got %q", i, step.msg, expand(step.reply), replies)
+				}
+			}
+		})
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	_, f := newTestServer(t)
+	key := newPublicKey(t)
+
+	replies := f.slash(alice, "register", map[string]string{"pubkey": key, "name": "ali"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "registered you as **ali**") {
+		t.Fatalf("registering: unexpected replies %q", replies)
+	}
+
+	replies = f.slash(admin, "ban", nil, alice)
+	if len(replies) != 1 || !strings.Contains(replies[0], "banned <@2>") {
+		t.Fatalf("banning: unexpected replies %q", replies)
+	}
+
+	// slash commands have a separate duration option, so a typo doesn't end up in the reason
+	replies = f.slash(admin, "suspend", map[string]string{"duration": "7days", "reason": "cheating"}, bob)
+	if len(replies) != 1 || !strings.Contains(replies[0], "not a valid duration") {
+		t.Fatalf("suspending with invalid duration: unexpected replies %q", replies)
+	}
+	replies = f.slash(admin, "gban", map[string]string{"ip": "1.2.3.4", "duration": "7days"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "not a valid duration") {
+		t.Fatalf("gbanning with invalid duration: unexpected replies %q", replies)
+	}
+	replies = f.slash(admin, "bans", nil)
+	if len(replies) != 1 || strings.Contains(replies[0], "<@3>") {
+		t.Errorf("suspended despite invalid duration: %q", replies)
+	}
+
+	replies = f.slash(alice, "whoami", nil)
+	if len(replies) != 1 || !strings.Contains(replies[0], "not registered") {
+		t.Fatalf("banned user still registered: %q", replies)
+	}
+}
+
+func TestParseTextCommand(t *testing.T) {
+	tests := []struct {
+		content string
+		name    string
+		args    map[string]string
+		err     bool
+	}{
+		{content: "+abc", name: "register", args: map[string]string{"pubkey": "+abc"}},
+		{content: "register +abc name", name: "register", args: map[string]string{"pubkey": "+abc", "name": "name"}},
+		{content: "OVERRIDE +abc", name: "override", args: map[string]string{"pubkey": "+abc"}},
+		{content: "key add laptop +abc", name: "addkey", args: map[string]string{"label": "laptop", "pubkey": "+abc"}},
+		{content: "replacekey laptop +abc 7d", name: "replacekey", args: map[string]string{"label": "laptop", "pubkey": "+abc", "grace": "7d"}},
+		{content: "gban 1.2.3 7d aimbot", name: "gban", args: map[string]string{"ip": "1.2.3", "duration": "7d", "reason": "aimbot"}},
+		{content: "gban 1.2.3 aimbot 7d", name: "gban", args: map[string]string{"ip": "1.2.3", "reason": "aimbot 7d"}},
+		{content: "register", err: true},
+		{content: "rename a b", err: true},
+		{content: "two words", err: true},
+	}
+
+	for _, test := range tests {
+		c, err := parseTextCommand(alice, test.content, nil)
+		if test.err {
+			if err == nil {
+				t.Errorf("parsing '%s': expected error, got %+v", test.content, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsing '%s': %v", test.content, err)
+			continue
+		}
+		if c.name != test.name {
+			t.Errorf("parsing '%s': expected command %s, got %s", test.content, test.name, c.name)
+		}
+		if len(c.args) != len(test.args) {
+			t.Errorf("parsing '%s': expected args %v, got %v", test.content, test.args, c.args)
+		}
+		for k, v := range test.args {
+			if c.args[k] != v {
+				t.Errorf("parsing '%s': expected %s = '%s', got '%s'", test.content, k, v, c.args[k])
+			}
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	roles := &fakeRoles{roles: testRoles}
+	perms := newPermissions(roles, "guild", map[string]struct{}{"10": {}}, map[string]struct{}{"11": {}}, time.Minute)
+
+	for id, expected := range map[string]permission{
+		admin.ID: permissionAdmin,
+		mod.ID:   permissionModerator,
+		alice.ID: permissionNone,
+		bob.ID:   permissionNone, // not a member
+	} {
+		for range 2 {
+			perm, err := perms.of(id)
+			if err != nil {
+				t.Fatalf("looking up permission of %s: %v", id, err)
+			}
+			if perm != expected {
+				t.Errorf("%s: expected permission %s, got %s", id, expected, perm)
+			}
+		}
+	}
+	if roles.lookups != 4 {
+		t.Errorf("expected roles to be looked up once per user, got %d lookups", roles.lookups)
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	s, f := newTestServer(t)
+
+	err := s.db.AddBan(db.Ban{
+		DiscordID: sql.NullString{String: alice.ID, Valid: true},
+		Name:      alice.String(),
+		ExpiresAt: sql.NullInt64{Int64: time.Now().Add(-time.Minute).Unix(), Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("adding expired ban: %v", err)
+	}
+
+	replies := f.send(alice, newPublicKey(t))
+	if len(replies) != 1 || !strings.Contains(replies[0], "registered you") {
+		t.Errorf("expired ban prevented registration: %q", replies)
+	}
+	replies = f.send(mod, "bans")
+	if len(replies) != 1 || !strings.Contains(replies[0], "Nobody is banned") {
+		t.Errorf("expired ban is still listed: %q", replies)
+	}
+}
+
+func TestIsNameBanned(t *testing.T) {
+	s, f := newTestServer(t)
+
+	check := func(name string, expected bool) {
+		t.Helper()
+		banned, err := s.isNameBanned(name)
+		if err != nil {
+			t.Fatalf("checking if %s is banned: %v", name, err)
+		}
+		if banned != expected {
+			t.Errorf("%s: expected banned = %v, got %v", name, expected, banned)
+		}
+	}
+
+	f.send(alice, newPublicKey(t))
+	check("alice", false)
+
+	f.send(mod, "suspend <@2>", alice)
+	check("alice", true)
+
+	f.send(admin, "unban <@2>", alice)
+	check("alice", false)
+
+	// a ban from before Discord IDs were stored, of an account that was re-added
+	s.db.MustExec("insert into `users` (`name`) values ('legacy#1234')")
+	s.db.MustExec("insert into `bans` (`name`) values ('legacy#1234')")
+	check("legacy#1234", true)
+	check("nobody", false)
+}
+
+func TestClaimLegacyAccount(t *testing.T) {
+	s, f := newTestServer(t)
+
+	s.db.MustExec("insert into `users` (`name`) values ('bob#1234')")
+	// users without a discriminator were stored as name#0
+	s.db.MustExec("insert into `users` (`name`) values ('alice#0')")
+	s.db.MustExec("insert into `bans` (`name`) values ('alice#0')")
+
+	f.send(bob, "help")
+	f.send(alice, "help")
+	if name, err := s.db.UserName(bob.ID); err != nil || name != "bob#1234" {
+		t.Errorf("expected bob to own bob#1234, got '%s', %v", name, err)
+	}
+	if name, err := s.db.UserName(alice.ID); err != nil || name != "alice#0" {
+		t.Errorf("expected alice to own alice#0, got '%s', %v", name, err)
+	}
+	if replies := f.send(alice, newPublicKey(t)); len(replies) != 1 || !strings.Contains(replies[0], "You are banned") {
+		t.Errorf("legacy ban of alice#0 doesn't apply to alice: %q", replies)
+	}
+
+	// legacy accounts are only looked for the first time a user shows up
+	s.db.MustExec("update `users` set `discord_id` = null where `name` = 'bob#1234'")
+	f.send(bob, "help")
+	if _, err := s.db.UserName(bob.ID); err == nil {
+		t.Error("legacy account was claimed again")
+	}
+}
+
+func TestNewServerNotifications(t *testing.T) {
+	defer func(interval time.Duration) { config.NotificationInterval = interval }(config.NotificationInterval)
+	config.NotificationInterval = time.Hour
+
+	s, f := newTestServer(t)
+	f.send(alice, newPublicKey(t))
+	f.send(bob, newPublicKey(t))
+
+	s.checkNewServer("alice", "10.0.0.1")
+	if len(f.notifications) != 1 {
+		t.Fatalf("expected one notification, got %d", len(f.notifications))
+	}
+	n := f.notifications[0]
+	if n.userID != alice.ID || !strings.Contains(n.content, "`10.0.0.1`") || len(n.buttons) != 1 {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+
+	// same server
+	s.checkNewServer("alice", "10.0.0.1")
+	// new server, but alice was notified a moment ago
+	s.checkNewServer("alice", "10.0.0.2")
+	if len(f.notifications) != 1 {
+		t.Errorf("expected no more notifications, got %+v", f.notifications[1:])
+	}
+
+	// the server is reported with the next login after the rate limit expired
+	s.db.MustExec("update `users` set `last_notified_at` = last_notified_at - 3600 where `name` = 'alice'")
+	s.checkNewServer("alice", "10.0.0.1")
+	if len(f.notifications) != 2 || !strings.Contains(f.notifications[1].content, "`10.0.0.2`") || f.notifications[1].buttons[0].command != "wasntme" {
+		t.Fatalf("expected notification about 10.0.0.2, got %+v", f.notifications[1:])
+	}
+	s.db.MustExec("update `users` set `last_notified_at` = last_notified_at - 3600 where `name` = 'alice'")
+	s.checkNewServer("alice", "10.0.0.2")
+	if len(f.notifications) != 2 {
+		t.Errorf("notified about known server: %+v", f.notifications[2:])
+	}
+	f.notifications = f.notifications[:1]
+
+	f.send(bob, "notifications off")
+	s.checkNewServer("bob#1234", "10.0.0.1")
+	if len(f.notifications) != 1 {
+		t.Errorf("bob was notified despite opting out: %+v", f.notifications[1:])
+	}
+
+	config.NotificationInterval = 0
+	f.send(bob, "notifications on")
+	s.checkNewServer("bob#1234", "10.0.0.1") // already seen while notifications were off
+	s.checkNewServer("bob#1234", "10.0.0.2")
+	if len(f.notifications) != 2 || f.notifications[1].userID != bob.ID || !strings.Contains(f.notifications[1].content, "`10.0.0.2`") {
+		t.Errorf("unexpected notifications after opting in again: %+v", f.notifications[1:])
+	}
+}
+
+func TestNotificationAboutManyServers(t *testing.T) {
+	defer func(interval time.Duration) { config.NotificationInterval = interval }(config.NotificationInterval)
+	config.NotificationInterval = time.Hour
+
+	s, f := newTestServer(t)
+	f.send(alice, newPublicKey(t))
+
+	// servers seen while alice was notified a moment ago are all reported with the next notification
+	s.checkNewServer("alice", "2001:db8::1")
+	for i := range 30 {
+		s.checkNewServer("alice", fmt.Sprintf("2001:db8:ffff:ffff:ffff:ffff:ffff:%x", i))
+	}
+	s.db.MustExec("update `users` set `last_notified_at` = last_notified_at - 3600 where `name` = 'alice'")
+	s.checkNewServer("alice", "2001:db8::1")
+
+	if len(f.notifications) != 2 {
+		t.Fatalf("expected two notifications, got %d", len(f.notifications))
+	}
+	n := f.notifications[1]
+	if !strings.Contains(n.content, "2001:db8:ffff:ffff:ffff:ffff:ffff:0") || !strings.Contains(n.content, "and 20 more") {
+		t.Errorf("unexpected notification: %s", n.content)
+	}
+	if len(n.buttons) != 1 || len(n.buttons[0].command) > 100 {
+		t.Errorf("button command is too long for Discord: %+v", n.buttons)
+	}
+	if replies := f.press(alice, n.buttons[0]); len(replies) != 1 || !strings.Contains(replies[0], "Your account is suspended") {
+		t.Errorf("pressing button: unexpected replies %q", replies)
+	}
+}
+
+func TestFailedNotification(t *testing.T) {
+	s, f := newTestServer(t)
+	f.send(alice, newPublicKey(t))
+
+	f.notifyErr = errors.New("DMs are closed")
+	s.checkNewServer("alice", "10.0.0.1")
+	f.notifyErr = nil
+	s.checkNewServer("alice", "10.0.0.1")
+	if len(f.notifications) != 1 || !strings.Contains(f.notifications[0].content, "`10.0.0.1`") {
+		t.Errorf("expected server to be reported again after failed notification, got %+v", f.notifications)
+	}
+}
+
+func TestWasntMe(t *testing.T) {
+	s, f := newTestServer(t)
+	key1, key2, laptopKey := newPublicKey(t), newPublicKey(t), newPublicKey(t)
+	f.send(alice, key1)
+	f.send(alice, "addkey laptop "+laptopKey)
+
+	s.checkNewServer("alice", "10.0.0.1")
+	if len(f.notifications) != 1 {
+		t.Fatalf("expected one notification, got %d", len(f.notifications))
+	}
+
+	replies := f.press(alice, f.notifications[0].buttons[0])
+	if len(replies) != 1 || !strings.Contains(replies[0], "Your account is suspended") {
+		t.Fatalf("pressing button: unexpected replies %q", replies)
+	}
+	banned, err := s.isNameBanned("alice")
+	if err != nil || !banned {
+		t.Fatalf("expected alice to be suspended, got %v, %v", banned, err)
+	}
+	replies = f.send(mod, "bans")
+	if len(replies) != 1 || !strings.Contains(replies[0], "login on a new server was reported as not legitimate") {
+		t.Errorf("suspension doesn't give the reason: %q", replies)
+	}
+
+	replies = f.send(alice, "override "+key1)
+	if len(replies) != 1 || !strings.Contains(replies[0], "new key pair") {
+		t.Errorf("suspension lifted with the old key: %q", replies)
+	}
+	replies = f.send(alice, "override "+laptopKey)
+	if len(replies) != 1 || !strings.Contains(replies[0], "new key pair") {
+		t.Errorf("suspension lifted with another old key: %q", replies)
+	}
+	replies = f.send(alice, "override "+key2)
+	if len(replies) != 1 || !strings.Contains(replies[0], "account is active again") || !strings.Contains(replies[0], "1 other key(s) were revoked") {
+		t.Fatalf("overriding: unexpected replies %q", replies)
+	}
+	// the key that was used by someone else could have been any of them
+	keys, err := s.getKeys("alice")
+	if err != nil || len(keys) != 1 || keys[0].PublicKey != key2 {
+		t.Errorf("expected only the new key to be left, got %v, %v", keys, err)
+	}
+	banned, err = s.isNameBanned("alice")
+	if err != nil || banned {
+		t.Errorf("expected alice not to be suspended anymore, got %v, %v", banned, err)
+	}
+
+	// suspensions by moderators can't be lifted that way
+	f.send(mod, "suspend <@2>", alice)
+	replies = f.send(alice, "override "+newPublicKey(t))
+	if len(replies) != 1 || !strings.Contains(replies[0], "Your account is suspended") {
+		t.Errorf("moderator suspension lifted by override: %q", replies)
+	}
+}
+
+func TestGBanPush(t *testing.T) {
+	s, f := newTestServer(t)
+
+	f.send(admin, "gban 10.0.0.0/8")
+	f.send(admin, "gban 1.2.3.4 cheating")
+	f.send(admin, "gban ::1") // not sent to game servers
+	expected := []string{"10.0.0.0/8", "1.2.3.4"}
+	if !slices.Equal(s.conns.gbans, expected) {
+		t.Errorf("expected gbans %q, got %q", expected, s.conns.gbans)
+	}
+
+	err := s.db.AddGBan(db.GBan{Prefix: "5.6.7.0/24", ExpiresAt: sql.NullInt64{Int64: time.Now().Add(-time.Minute).Unix(), Valid: true}})
+	if err != nil {
+		t.Fatalf("adding expired gban: %v", err)
+	}
+	f.send(admin, "ungban 10.0.0.0/8")
+	expected = []string{"1.2.3.4"}
+	if !slices.Equal(s.conns.gbans, expected) {
+		t.Errorf("expected gbans %q, got %q", expected, s.conns.gbans)
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"1.2.3.4", "1.2.3.4/32"},
+		{"1.2.3", "1.2.3.0/24"},
+		{"1", "1.0.0.0/8"},
+		{"1.2.3.4/16", "1.2.0.0/16"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"1.2.3.256", ""},
+		{"1.2.3.4.5", ""},
+		{"1.2.3.4/33", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		p, err := parseIPRange(test.in)
+		if test.out == "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", test.in, p)
+			}
+			continue
+		}
+		if err != nil || p.String() != test.out {
+			t.Errorf("%s: expected %s, got %s, %v", test.in, test.out, p, err)
+		}
+	}
+}
+
+func TestWasntMeAfterSuspension(t *testing.T) {
+	s, f := newTestServer(t)
+	f.send(alice, newPublicKey(t))
+	f.send(mod, "suspend <@2> cheating", alice)
+
+	replies := f.send(alice, "wasntme")
+	if len(replies) != 1 || !strings.Contains(replies[0], "Your account is suspended") {
+		t.Errorf("wasntme: unexpected replies %q", replies)
+	}
+	replies = f.send(alice, "override "+newPublicKey(t))
+	if len(replies) != 1 || !strings.Contains(replies[0], "Your account is suspended") {
+		t.Errorf("moderator suspension lifted by wasntme and override: %q", replies)
+	}
+	banned, err := s.isNameBanned("alice")
+	if err != nil || !banned {
+		t.Errorf("expected alice to still be suspended, got %v, %v", banned, err)
+	}
+	replies = f.send(mod, "bans")
+	if len(replies) != 1 || !strings.Contains(replies[0], "by <@4>: cheating") {
+		t.Errorf("suspension by moderator was replaced: %q", replies)
+	}
+
+	// other moderators can't replace the suspension either
+	replies = f.send(admin, "ban <@2>", alice)
+	if len(replies) != 1 || !strings.Contains(replies[0], "already suspended by <@4>") {
+		t.Errorf("banning suspended user: unexpected replies %q", replies)
+	}
+	replies = f.send(alice, "whoami")
+	if len(replies) != 1 || !strings.Contains(replies[0], "suspended") {
+		t.Errorf("account of suspended user was deleted: %q", replies)
+	}
+}
+
+func TestKickOnBan(t *testing.T) {
+	s, f := newTestServer(t)
+	f.send(alice, newPublicKey(t))
+	f.send(bob, newPublicKey(t))
+
+	h := newHandler(nil, nil, time.Minute, 10, nil, nil, nil, nil, s.gameServers, nil, s.conns)
+	s.conns.add(h)
+	s.conns.authenticated(h, alice.ID, "alice")
+	s.conns.authenticated(h, bob.ID, "bob#1234")
+
+	expectKick := func(name, reason string) {
+		t.Helper()
+		select {
+		case k := <-h.kicks:
+			if k.name != name || k.reason != reason {
+				t.Errorf("expected kick of %s (%s), got %+v", name, reason, k)
+			}
+		default:
+			t.Errorf("expected kick of %s", name)
+		}
+	}
+
+	// game servers know players by the name they authenticated with, even if the account was renamed since
+	if replies := f.send(alice, "rename ali"); len(replies) != 1 || !strings.Contains(replies[0], "You are now **ali**") {
+		t.Fatalf("renaming: unexpected replies %q", replies)
+	}
+	replies := f.send(mod, "ban <@2> 1d cheating", alice)
+	if len(replies) != 1 || !strings.Contains(replies[0], "kicking them from 1 game server(s)") {
+		t.Errorf("banning: unexpected replies %q", replies)
+	}
+	expectKick("alice", "cheating")
+
+	f.send(mod, "suspend <@3>", bob)
+	expectKick("bob#1234", "")
+
+	// unregistered users have nothing to be kicked for
+	replies = f.send(mod, "ban <@4>", mod)
+	if len(replies) != 1 || strings.Contains(replies[0], "kicking") {
+		t.Errorf("banning unregistered user: unexpected replies %q", replies)
+	}
+
+	s.conns.remove(h)
+	f.send(admin, "unban <@2>", alice)
+	f.send(alice, newPublicKey(t))
+	replies = f.send(mod, "ban <@2>", alice)
+	if len(replies) != 1 || strings.Contains(replies[0], "kicking") {
+		t.Errorf("kick sent to disconnected game server: %q", replies)
+	}
+}
@@ -3,14 +3,59 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
-	DiscordToken = mustEnv("DISCORD_TOKEN")
-	Admins       = parseListAsSet(mustEnv("ADMINS"))
+	DiscordToken string
+
+	// the guild whose roles determine who may run moderator and admin commands
+	GuildID string
+	// IDs of roles that grant admin or moderator permissions
+	AdminRoles     map[string]struct{}
+	ModeratorRoles map[string]struct{}
+	// how long to remember a user's roles
+	RoleCacheTTL time.Duration
+
+	// how long a game server has to answer a challenge before the request fails
+	ChallengeLifetime time.Duration
+	// how many unanswered challenges a game server connection may have at once
+	MaxPendingChallenges int
+
+	// how long a game server stays in the server list after registering; game servers register again every hour
+	GameServerTTL time.Duration
+	// how long to wait for a registering game server to answer the ping
+	PingTimeout time.Duration
+
+	// how long to keep records of authentication attempts
+	AuthEventRetention time.Duration
+
+	// minimum time between two notifications about an account being used on a new game server
+	NotificationInterval time.Duration
+
+	// where to serve metrics over HTTP (e.g. 'localhost:8080'); not served if empty
+	MetricsAddr string
 )
 
+// Load reads the settings above from the environment. It exits the program if a required setting is missing or a
+// setting is invalid.
+func Load() {
+	DiscordToken = mustEnv("DISCORD_TOKEN")
+	GuildID = mustEnv("GUILD_ID")
+	AdminRoles = parseListAsSet(mustEnv("ADMIN_ROLES"))
+	ModeratorRoles = parseListAsSet(os.Getenv("MODERATOR_ROLES"))
+	RoleCacheTTL = durationEnv("ROLE_CACHE_TTL", 5*time.Minute)
+	ChallengeLifetime = durationEnv("CHALLENGE_LIFETIME", 30*time.Second)
+	MaxPendingChallenges = intEnv("MAX_PENDING_CHALLENGES", 100)
+	GameServerTTL = durationEnv("GAME_SERVER_TTL", 65*time.Minute)
+	PingTimeout = durationEnv("PING_TIMEOUT", 5*time.Second)
+	AuthEventRetention = durationEnv("AUTH_EVENT_RETENTION", 90*24*time.Hour)
+	NotificationInterval = durationEnv("NOTIFICATION_INTERVAL", time.Hour)
+	MetricsAddr = os.Getenv("METRICS_ADDR")
+}
+
 func mustEnv(name string) string {
 	value := os.Getenv(name)
 	if value == "" {
@@ -19,6 +64,30 @@ func mustEnv(name string) string {
 	return value
 }
 
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s must be a positive duration like '30s', got '%s'\n", name, value)
+	}
+	return d
+}
+
+func intEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		log.Fatalf("%s must be a positive integer, got '%s'\n", name, value)
+	}
+	return i
+}
+
 func parseListAsSet(s string) map[string]struct{} {
 	set := map[string]struct{}{}
 	for _, elem := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' }) {
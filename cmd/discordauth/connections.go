@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// connections keeps track of the connected game servers and the accounts used on them, to push updates to them.
+type connections struct {
+	mutex    sync.Mutex
+	handlers map[*handler]struct{}
+	gbans    []string                        // the current gban list, as arguments of addgban messages
+	accounts map[string]map[session]struct{} // where each account authenticated, by Discord ID of the owner
+}
+
+// session is an authentication of an account on a game server. The name is the one used to authenticate, which game
+// servers know the player by, even if the account was renamed since.
+type session struct {
+	h    *handler
+	name string
+}
+
+func newConnections() *connections {
+	return &connections{
+		handlers: map[*handler]struct{}{},
+		gbans:    []string{},
+		accounts: map[string]map[session]struct{}{},
+	}
+}
+
+// add starts pushing updates to the game server connected to h and sends it the current gban list.
+func (c *connections) add(h *handler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.handlers[h] = struct{}{}
+	h.pushGBans(c.gbans)
+}
+
+func (c *connections) remove(h *handler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.handlers, h)
+	for discordID, sessions := range c.accounts {
+		for sess := range sessions {
+			if sess.h == h {
+				delete(sessions, sess)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(c.accounts, discordID)
+		}
+	}
+}
+
+// authenticated records that a player authenticated as name, the account of the Discord user with the given ID, on the
+// game server connected to h. Players leaving the game server are not tracked; the account stays associated with the
+// game server until it disconnects. Accounts without Discord ID can't be banned and are not recorded.
+func (c *connections) authenticated(h *handler, discordID, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.handlers[h]; !ok {
+		return // already disconnected
+	}
+	if discordID == "" {
+		return
+	}
+	if c.accounts[discordID] == nil {
+		c.accounts[discordID] = map[session]struct{}{}
+	}
+	c.accounts[discordID][session{h, name}] = struct{}{}
+}
+
+// kick asks all game servers the account of the Discord user with the given ID authenticated on to kick the players
+// using it, and returns how many game servers were asked.
+func (c *connections) kick(discordID, reason string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	asked := map[*handler]struct{}{}
+	for sess := range c.accounts[discordID] {
+		if sess.h.pushKick(kick{name: sess.name, reason: reason}) {
+			asked[sess.h] = struct{}{}
+		}
+	}
+	return len(asked)
+}
+
+// setGBans replaces the gban list and sends it to all connected game servers if it changed.
+func (c *connections) setGBans(gbans []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if slices.Equal(gbans, c.gbans) {
+		return
+	}
+	c.gbans = gbans
+	for h := range c.handlers {
+		h.pushGBans(gbans)
+	}
+}
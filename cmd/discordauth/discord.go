@@ -4,18 +4,30 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/bwmarrin/discordgo"
+)
+
+// discordFrontend receives commands as slash commands and as text in DMs.
+type discordFrontend struct {
+	token   string
+	session *discordgo.Session
+}
 
-	"github.com/sauerbraten/maitred/v2/cmd/discordauth/config"
-	"github.com/sauerbraten/maitred/v2/internal/db"
+var (
+	_ frontend   = (*discordFrontend)(nil)
+	_ roleSource = (*discordFrontend)(nil)
+	_ notifier   = (*discordFrontend)(nil)
 )
 
-func startDiscord(s *Server) func() {
-	d, err := discordgo.New("Bot " + config.DiscordToken)
+func newDiscordFrontend(token string) *discordFrontend {
+	return &discordFrontend{token: token}
+}
+
+func (f *discordFrontend) start(handle func(*command, replier)) error {
+	d, err := discordgo.New("Bot " + f.token)
 	if err != nil {
-		log.Fatalf("discord: error creating session: %v\n", err)
+		return fmt.Errorf("discord: creating session: %w", err)
 	}
 
 	d.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) {
@@ -26,6 +38,17 @@ func startDiscord(s *Server) func() {
 		log.Println("discord: disconnected")
 	})
 
+	d.AddHandler(func(d *discordgo.Session, r *discordgo.Ready) {
+		_, err := d.ApplicationCommandBulkOverwrite(r.User.ID, "", applicationCommands())
+		if err != nil {
+			log.Printf("discord: registering slash commands: %v\n", err)
+		}
+	})
+
+	d.AddHandler(func(d *discordgo.Session, i *discordgo.InteractionCreate) {
+		handleInteraction(d, i.Interaction, handle)
+	})
+
 	d.AddHandler(func(d *discordgo.Session, m *discordgo.MessageCreate) {
 		if m.Author == nil || m.Author.ID == d.State.User.ID {
 			return
@@ -39,99 +62,212 @@ func startDiscord(s *Server) func() {
 			return
 		}
 
-		handleMessage(d, m.Message, s)
+		handleMessage(d, m.Message, handle)
 	})
 
 	d.Identify.Intents = discordgo.IntentsDirectMessages
 
 	err = d.Open()
 	if err != nil {
-		log.Fatalf("discord: error opening session: %v\n", err)
+		return fmt.Errorf("discord: opening session: %w", err)
 	}
 
-	return func() {
-		err := d.Close()
-		if err != nil {
-			log.Printf("discord: error closing connection: %v\n", err)
-		}
+	f.session = d
+	return nil
+}
+
+func (f *discordFrontend) stop() error {
+	err := f.session.Close()
+	if err != nil {
+		return fmt.Errorf("discord: closing connection: %w", err)
 	}
+	return nil
 }
 
-func sendMessage(d *discordgo.Session, channelID, content string) {
-	_, err := d.ChannelMessageSend(channelID, content)
+func (f *discordFrontend) memberRoles(guildID, userID string) ([]string, error) {
+	if f.session == nil {
+		return nil, errors.New("discord: not connected")
+	}
+	m, err := f.session.GuildMember(guildID, userID)
+	if restErr := new(discordgo.RESTError); errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMember {
+		return nil, nil
+	}
 	if err != nil {
-		log.Printf("error replying with '%s': %v\n", content, err)
+		return nil, fmt.Errorf("discord: getting member %s of guild %s: %w", userID, guildID, err)
 	}
+	return m.Roles, nil
 }
 
-func handleMessage(d *discordgo.Session, m *discordgo.Message, s *Server) {
-	authorName := removeWhitespace(m.Author.Username) + "#" + m.Author.Discriminator
+func (f *discordFrontend) notify(userID, content string, buttons ...button) error {
+	if f.session == nil {
+		return errors.New("discord: not connected")
+	}
 
-	switch fields := strings.Fields(m.Content); fields[0] {
-	case "ban":
-		if _, ok := config.Admins[authorName]; !ok {
-			return
+	ch, err := f.session.UserChannelCreate(userID)
+	if err != nil {
+		return fmt.Errorf("discord: opening DM channel with %s: %w", userID, err)
+	}
+
+	msg := &discordgo.MessageSend{Content: content}
+	if len(buttons) > 0 {
+		row := discordgo.ActionsRow{}
+		for _, b := range buttons {
+			row.Components = append(row.Components, discordgo.Button{
+				Label:    b.label,
+				Style:    discordgo.DangerButton,
+				CustomID: b.command,
+			})
 		}
-		for _, user := range m.Mentions {
-			targetName := user.Username + "#" + user.Discriminator
-			err := s.delUser(targetName)
-			if err != nil {
-				sendMessage(d, m.ChannelID, fmt.Sprintf(":boom: deleting %s: %v", targetName, err))
-				continue
-			}
-			err = s.banUser(targetName)
-			if err != nil {
-				sendMessage(d, m.ChannelID, fmt.Sprintf(":boom: banning %s: %v", targetName, err))
-				continue
-			}
-			sendMessage(d, m.ChannelID, fmt.Sprintf(":white_check_mark: banned %s", targetName))
+		msg.Components = []discordgo.MessageComponent{row}
+	}
+
+	_, err = f.session.ChannelMessageSendComplex(ch.ID, msg)
+	if err != nil {
+		return fmt.Errorf("discord: sending DM to %s: %w", userID, err)
+	}
+	return nil
+}
+
+// applicationCommands translates the commands the router knows about into Discord's format.
+func applicationCommands() []*discordgo.ApplicationCommand {
+	cmds := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	for _, spec := range commands {
+		if spec.hidden {
+			continue
 		}
-	case "unban":
-		if _, ok := config.Admins[authorName]; !ok {
-			return
+		cmd := &discordgo.ApplicationCommand{
+			Name:        spec.name,
+			Description: spec.description,
+			Contexts:    &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild, discordgo.InteractionContextBotDM},
 		}
-		for _, user := range m.Mentions {
-			targetName := user.Username + "#" + user.Discriminator
-			err := s.unbanUser(targetName)
-			if err != nil {
-				sendMessage(d, m.ChannelID, fmt.Sprintf(":boom: unbanning %s: %v", targetName, err))
-				continue
+		for _, opt := range spec.options {
+			typ := discordgo.ApplicationCommandOptionString
+			if opt.typ == optionUser {
+				typ = discordgo.ApplicationCommandOptionUser
 			}
-			sendMessage(d, m.ChannelID, fmt.Sprintf(":white_check_mark: unbanned %s", targetName))
+			cmd.Options = append(cmd.Options, &discordgo.ApplicationCommandOption{
+				Type:        typ,
+				Name:        opt.name,
+				Description: opt.description,
+				Required:    opt.required,
+			})
 		}
-	default: // normal user registering
-		content := m.Content
-		override := false
-		if strings.HasPrefix(content, "override ") {
-			override = true
-			content = content[len("override "):]
-		}
-		banned, err := s.isBanned(authorName)
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func toUser(u *discordgo.User) user {
+	return user{ID: u.ID, Username: u.Username, Discriminator: u.Discriminator}
+}
+
+// interactionReplier responds to a slash command with a message only its author can see.
+type interactionReplier struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+}
+
+func (r interactionReplier) reply(content string) {
+	err := r.session.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("discord: responding to interaction with '%s': %v\n", content, err)
+	}
+}
+
+// channelReplier replies by sending a message to a channel.
+type channelReplier struct {
+	session   *discordgo.Session
+	channelID string
+}
+
+func (r channelReplier) reply(content string) {
+	_, err := r.session.ChannelMessageSend(r.channelID, content)
+	if err != nil {
+		log.Printf("discord: replying with '%s': %v\n", content, err)
+	}
+}
+
+func handleInteraction(d *discordgo.Session, i *discordgo.Interaction, handle func(*command, replier)) {
+	author := i.User
+	if i.Member != nil {
+		author = i.Member.User
+	}
+	if author == nil {
+		return
+	}
+
+	r := interactionReplier{d, i}
+
+	var c *command
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		c = slashCommand(i.ApplicationCommandData(), toUser(author))
+	case discordgo.InteractionMessageComponent:
+		// a button of a notification
+		var err error
+		c, err = parseTextCommand(toUser(author), i.MessageComponentData().CustomID, nil)
 		if err != nil {
-			log.Printf("discord: checking if %s is banned: %v", authorName, err)
-			log.Printf("discord: ignoring message: %s\n", m.Content)
-			sendMessage(d, m.ChannelID, "That didn't work! :thinking: I can't tell if you are banned or not.")
-			return
-		}
-		if banned {
+			r.reply(textCommandError(err))
 			return
 		}
-		err = s.addUser(authorName, content, override)
-		if err != nil {
-			if existsErr := new(db.UserExistsError); errors.As(err, existsErr) {
-				sendMessage(d, m.ChannelID, fmt.Sprintf("You are already registered (your public key is: %s).\nTo replace your registered public key, send `override %s`.", existsErr.PublicKey, content))
-			} else {
-				log.Println("discord: adding user:", err)
-				log.Printf("discord: ignoring message: %s\n", m.Content)
-				sendMessage(d, m.ChannelID, fmt.Sprintf("That didn't work! :dizzy_face: To register, follow these steps:\n 1. in Sauerbraten, run `/authkey \"%s\" (genauthkey (rndstr 32)) p1x.pw; saveauthkeys; echo (getpubkey p1x.pw)`\n 2. send me the last line of output here (it's easiest to copy this from the command line window)\n", authorName))
+	default:
+		return
+	}
+
+	handled := false
+	handle(c, replierFunc(func(content string) {
+		handled = true
+		r.reply(content)
+	}))
+	if !handled {
+		// Discord shows an error unless every interaction gets a response
+		r.reply(":ok_hand:")
+	}
+}
+
+func slashCommand(data discordgo.ApplicationCommandInteractionData, author user) *command {
+	c := &command{name: data.Name, author: author, args: map[string]string{}}
+	for _, opt := range data.Options {
+		switch opt.Type {
+		case discordgo.ApplicationCommandOptionString:
+			c.args[opt.Name] = opt.StringValue()
+		case discordgo.ApplicationCommandOptionUser:
+			target := opt.UserValue(nil)
+			if data.Resolved != nil {
+				if u, ok := data.Resolved.Users[target.ID]; ok {
+					target = u
+				}
 			}
-			return
+			c.targets = append(c.targets, toUser(target))
 		}
-		log.Printf("discord: %s registered using public key %s\n", authorName, content)
-		sendMessage(d, m.ChannelID, fmt.Sprintf("registered you as **%s**!", authorName))
 	}
+	return c
 }
 
-func removeWhitespace(s string) string {
-	return strings.Join(strings.Fields(s), "")
+// handleMessage handles commands sent as text in a DM.
+func handleMessage(d *discordgo.Session, m *discordgo.Message, handle func(*command, replier)) {
+	mentions := make([]user, len(m.Mentions))
+	for i, u := range m.Mentions {
+		mentions[i] = toUser(u)
+	}
+
+	r := channelReplier{d, m.ChannelID}
+
+	c, err := parseTextCommand(toUser(m.Author), m.Content, mentions)
+	if err != nil {
+		r.reply(textCommandError(err))
+		return
+	}
+	if c == nil {
+		// e.g. a message with only an attachment
+		return
+	}
+
+	handle(c, r)
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSlashCommand(t *testing.T) {
+	s, f := newTestServer(t)
+
+	run := func(author user, data discordgo.ApplicationCommandInteractionData) string {
+		t.Helper()
+		replies := []string{}
+		f.handle(slashCommand(data, author), replierFunc(func(content string) {
+			replies = append(replies, content)
+		}))
+		if len(replies) != 1 {
+			t.Fatalf("/%s: expected one reply, got %q", data.Name, replies)
+		}
+		return replies[0]
+	}
+
+	reply := run(alice, discordgo.ApplicationCommandInteractionData{
+		Name: "register",
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "pubkey", Type: discordgo.ApplicationCommandOptionString, Value: newPublicKey(t)},
+			{Name: "name", Type: discordgo.ApplicationCommandOptionString, Value: "ali"},
+		},
+	})
+	if !strings.Contains(reply, "registered you as **ali**") {
+		t.Errorf("/register: unexpected reply %q", reply)
+	}
+
+	// user options only carry the ID; the rest of the user is taken from the resolved data
+	ban := discordgo.ApplicationCommandInteractionData{
+		Name: "ban",
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "user", Type: discordgo.ApplicationCommandOptionUser, Value: bob.ID},
+			{Name: "reason", Type: discordgo.ApplicationCommandOptionString, Value: "cheating"},
+		},
+		Resolved: &discordgo.ApplicationCommandInteractionDataResolved{
+			Users: map[string]*discordgo.User{bob.ID: {ID: bob.ID, Username: bob.Username, Discriminator: bob.Discriminator}},
+		},
+	}
+	c := slashCommand(ban, mod)
+	if len(c.targets) != 1 || c.targets[0] != bob || c.args["reason"] != "cheating" {
+		t.Errorf("/ban: unexpected command %+v", c)
+	}
+
+	if reply := run(alice, ban); !strings.Contains(reply, "not allowed") {
+		t.Errorf("/ban by alice: unexpected reply %q", reply)
+	}
+	if reply := run(mod, ban); !strings.Contains(reply, "banned <@3> permanently (cheating)") {
+		t.Errorf("/ban by mod: unexpected reply %q", reply)
+	}
+	bans, err := s.getBans()
+	if err != nil || len(bans) != 1 || bans[0].Name != "bob#1234" {
+		t.Errorf("expected bob#1234 to be banned, got %v, %v", bans, err)
+	}
+
+	if reply := run(alice, discordgo.ApplicationCommandInteractionData{Name: "nope"}); !strings.Contains(reply, "I don't know that command") {
+		t.Errorf("/nope: unexpected reply %q", reply)
+	}
+}
+
+func TestApplicationCommands(t *testing.T) {
+	validName := regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+	byName := map[string]*discordgo.ApplicationCommand{}
+	for _, cmd := range applicationCommands() {
+		if _, ok := byName[cmd.Name]; ok {
+			t.Errorf("%s: defined twice", cmd.Name)
+		}
+		byName[cmd.Name] = cmd
+
+		// Discord rejects all commands if one of them breaks these rules
+		if !validName.MatchString(cmd.Name) {
+			t.Errorf("%s: invalid name", cmd.Name)
+		}
+		if n := len(cmd.Description); n == 0 || n > 100 {
+			t.Errorf("%s: description must have 1 to 100 characters, has %d", cmd.Name, n)
+		}
+		optional := false
+		for _, opt := range cmd.Options {
+			if !validName.MatchString(opt.Name) {
+				t.Errorf("%s: invalid option name '%s'", cmd.Name, opt.Name)
+			}
+			if n := len(opt.Description); n == 0 || n > 100 {
+				t.Errorf("%s: description of option '%s' must have 1 to 100 characters, has %d", cmd.Name, opt.Name, n)
+			}
+			if opt.Required && optional {
+				t.Errorf("%s: required option '%s' follows an optional one", cmd.Name, opt.Name)
+			}
+			optional = optional || !opt.Required
+		}
+	}
+
+	for _, spec := range commands {
+		cmd, ok := byName[spec.name]
+		if spec.hidden {
+			if ok {
+				t.Errorf("%s: hidden command is offered as slash command", spec.name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: not offered as slash command", spec.name)
+			continue
+		}
+		for i, opt := range spec.options {
+			typ := discordgo.ApplicationCommandOptionString
+			if opt.typ == optionUser {
+				typ = discordgo.ApplicationCommandOptionUser
+			}
+			if cmd.Options[i].Type != typ {
+				t.Errorf("%s: option '%s' has type %s, expected %s", spec.name, opt.name, cmd.Options[i].Type, typ)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+)
+
+// replier sends replies to whoever issued a command.
+type replier interface {
+	reply(content string)
+}
+
+// frontend is a chat service users send commands from.
+type frontend interface {
+	// start connects to the chat service and calls handle with every command received until stop is called.
+	start(handle func(c *command, r replier)) error
+	stop() error
+}
+
+// button is attached to a notification. Pressing it runs command, given in text message syntax, as if the user sent it.
+type button struct {
+	label   string
+	command string
+}
+
+// notifier is implemented by frontends that can send messages to users on their own.
+type notifier interface {
+	notify(userID, content string, buttons ...button) error
+}
+
+// serve handles commands from f until the server is stopped. If f is a notifier, it is used to send notifications.
+func (s *Server) serve(f frontend) error {
+	if n, ok := f.(notifier); ok {
+		s.notifier = n
+	}
+
+	err := f.start(func(c *command, r replier) {
+		reply := s.handleCommand(c)
+		if reply != "" {
+			r.reply(reply)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-s.stop
+		err := f.stop()
+		if err != nil {
+			log.Printf("error stopping frontend: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// replierFunc adapts a function to the replier interface.
+type replierFunc func(content string)
+
+func (f replierFunc) reply(content string) { f(content) }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+)
+
+// fakeFrontend is an in-memory frontend. Commands are handled synchronously.
+type fakeFrontend struct {
+	handle        func(*command, replier)
+	notifications []notification
+	notifyErr     error // returned by notify instead of recording the notification
+}
+
+// notification is a message sent by fakeFrontend.notify.
+type notification struct {
+	userID  string
+	content string
+	buttons []button
+}
+
+var (
+	_ frontend = (*fakeFrontend)(nil)
+	_ notifier = (*fakeFrontend)(nil)
+)
+
+func (f *fakeFrontend) start(handle func(*command, replier)) error {
+	f.handle = handle
+	return nil
+}
+
+func (f *fakeFrontend) stop() error { return nil }
+
+func (f *fakeFrontend) notify(userID, content string, buttons ...button) error {
+	if f.notifyErr != nil {
+		return f.notifyErr
+	}
+	f.notifications = append(f.notifications, notification{userID, content, buttons})
+	return nil
+}
+
+// press presses a button of a notification as author and returns the replies.
+func (f *fakeFrontend) press(author user, b button) []string {
+	return f.send(author, b.command)
+}
+
+// send delivers a text message and returns the replies to it.
+func (f *fakeFrontend) send(author user, content string, mentions ...user) []string {
+	replies := []string{}
+	r := replierFunc(func(content string) { replies = append(replies, content) })
+
+	c, err := parseTextCommand(author, content, mentions)
+	if err != nil {
+		r.reply(textCommandError(err))
+		return replies
+	}
+	if c != nil {
+		f.handle(c, r)
+	}
+	return replies
+}
+
+// slash runs a slash command and returns the replies to it.
+func (f *fakeFrontend) slash(author user, name string, args map[string]string, targets ...user) []string {
+	replies := []string{}
+	if args == nil {
+		args = map[string]string{}
+	}
+	f.handle(&command{name: name, author: author, args: args, targets: targets}, replierFunc(func(content string) {
+		replies = append(replies, content)
+	}))
+	return replies
+}
+
+// fakeRoles is a roleSource backed by a map from user IDs to role IDs. It counts lookups.
+type fakeRoles struct {
+	mutex   sync.Mutex
+	roles   map[string][]string
+	lookups int
+}
+
+var _ roleSource = (*fakeRoles)(nil)
+
+func (f *fakeRoles) memberRoles(_, userID string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.lookups++
+	return f.roles[userID], nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// gameServers is the list of game servers shown in the clients' server browser. Game servers register again
+// periodically; a server that doesn't is dropped from the list after ttl.
+type gameServers struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	servers map[netip.AddrPort]time.Time // time of the last registration
+}
+
+func newGameServers(ttl time.Duration) *gameServers {
+	return &gameServers{
+		ttl:     ttl,
+		servers: map[netip.AddrPort]time.Time{},
+	}
+}
+
+// register adds the game server at addr to the list or extends its registration.
+func (g *gameServers) register(addr netip.AddrPort) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.servers[addr] = time.Now()
+}
+
+// list returns the addresses of all registered game servers, sorted. Expired registrations are removed.
+func (g *gameServers) list() []netip.AddrPort {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	addrs := make([]netip.AddrPort, 0, len(g.servers))
+	for addr, registeredAt := range g.servers {
+		if time.Since(registeredAt) > g.ttl {
+			delete(g.servers, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, netip.AddrPort.Compare)
+	return addrs
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/sauerbraten/maitred/v2/pkg/client"
+)
+
+var errInvalidIPRange = errors.New("invalid IP range")
+
+// parseIPRange parses an IP address, an IP range in CIDR notation (IPv4 or IPv6), or a partial IPv4 address like
+// '1.2.3' as used by Sauerbraten's ban commands, which means 1.2.3.0/24.
+func parseIPRange(s string) (netip.Prefix, error) {
+	p, err := client.ParseIPRange(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: %v", errInvalidIPRange, err)
+	}
+	return p, nil
+}
+
+// gbanArg formats p as argument of an addgban message. Game servers only understand IPv4, so ok is false for IPv6
+// ranges.
+func gbanArg(p netip.Prefix) (arg string, ok bool) {
+	if !p.Addr().Is4() {
+		return "", false
+	}
+	if p.IsSingleIP() {
+		return p.Addr().String(), true
+	}
+	return p.String(), true
+}
@@ -5,11 +5,15 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"time"
 
+	"github.com/sauerbraten/maitred/v2/cmd/discordauth/config"
 	"github.com/sauerbraten/maitred/v2/internal/db"
 )
 
 func main() {
+	config.Load()
+
 	addr, err := net.ResolveTCPAddr("tcp", ":28787")
 	if err != nil {
 		log.Fatalln("error starting to listen on :28787:", err)
@@ -22,16 +26,34 @@ func main() {
 
 	stop := make(chan struct{})
 
-	s := newServer(addr, db, stop)
+	discord := newDiscordFrontend(config.DiscordToken)
+	perms := newPermissions(discord, config.GuildID, config.AdminRoles, config.ModeratorRoles, config.RoleCacheTTL)
+
+	authEvents := db.NewAuthEventWriter(100, 5*time.Second, config.AuthEventRetention)
+
+	gameServers := newGameServers(config.GameServerTTL)
+
+	s := newServer(addr, db, perms, authEvents, gameServers, stop)
+
+	err = s.serve(discord)
+	if err != nil {
+		log.Fatalln("error connecting to Discord:", err)
+	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
 	go s.Listen()
 
+	if config.MetricsAddr != "" {
+		go serveMetrics(config.MetricsAddr)
+	}
+
 	<-interrupt
 	close(stop) // disconnects from Discord
 
+	authEvents.Close()
+
 	err = db.Close()
 	if err != nil {
 		log.Fatalln("error closing users database:", err)
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// authResults counts the outcomes of auth requests from game servers by result.
+var authResults = expvar.NewMap("auth_results")
+
+const (
+	resultSuccess         = "success"
+	resultWrongAnswer     = "wrong_answer"
+	resultMalformedAnswer = "malformed_answer"
+	resultUnknownRequest  = "unknown_request"
+	resultExpired         = "expired"
+	resultUnknownUser     = "unknown_user"
+	resultBanned          = "banned" // includes suspended users
+	resultTooManyPending  = "too_many_pending"
+	resultError           = "error" // e.g. the ban check failed
+)
+
+// gameServerRegistrations counts regserv requests by whether the game server answered the ping.
+var gameServerRegistrations = expvar.NewMap("game_server_registrations")
+
+// serveMetrics exposes the expvar metrics at /debug/vars.
+func serveMetrics(addr string) {
+	log.Println("serving metrics on", addr)
+	err := http.ListenAndServe(addr, nil)
+	if err != nil {
+		log.Printf("error serving metrics on %s: %v", addr, err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxAuthNameLength is the longest auth name game servers keep: they store it in a buffer of Sauerbraten's MAXSTRLEN
+// (260 bytes, including the terminating null byte). MAXNAMELEN only limits player names, not auth names.
+const maxAuthNameLength = 259
+
+var errInvalidAuthName = errors.New("invalid name")
+
+// validateAuthName checks that players can use name as their auth name. Names are restricted to the printable ASCII
+// part of Sauerbraten's cube string charset, which everybody can type in the game console, minus characters that can't
+// be used inside a quoted CubeScript string (`"` and `^`) and whitespace, which separates arguments in the master
+// server protocol.
+func validateAuthName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", errInvalidAuthName)
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == '"' || c == '^' {
+			return fmt.Errorf("%w: '%c' is not allowed", errInvalidAuthName, c)
+		}
+	}
+	if len(name) > maxAuthNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", errInvalidAuthName, maxAuthNameLength)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateAuthName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"alice", true},
+		{"bob#1234", true},
+		{"[TAG]p1x_-.~", true},
+		{strings.Repeat("x", 32) + "#1234", true}, // longest Discord handle
+		{strings.Repeat("x", maxAuthNameLength), true},
+		{strings.Repeat("x", maxAuthNameLength+1), false},
+		{"", false},
+		{"a b", false},
+		{"a\tb", false},
+		{`a"b`, false},
+		{"a^b", false},
+		{"jörg", false},
+		{"a\x7f", false},
+	}
+	for _, test := range tests {
+		err := validateAuthName(test.name)
+		if test.valid && err != nil {
+			t.Errorf("'%s': expected name to be valid, got %v", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, errInvalidAuthName) {
+			t.Errorf("'%s': expected %v, got %v", test.name, errInvalidAuthName, err)
+		}
+	}
+}
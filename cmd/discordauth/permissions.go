@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type permission int
+
+const (
+	permissionNone permission = iota
+	permissionModerator
+	permissionAdmin
+)
+
+func (p permission) String() string {
+	switch p {
+	case permissionModerator:
+		return "moderator"
+	case permissionAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// roleSource looks up which roles a user has in a guild.
+type roleSource interface {
+	// memberRoles returns the IDs of userID's roles in guildID. If the user is not a member of the guild, no roles and
+	// no error are returned.
+	memberRoles(guildID, userID string) ([]string, error)
+}
+
+type cachedPermission struct {
+	permission
+	fetchedAt time.Time
+}
+
+// permissions determines what users are allowed to do from their roles in a guild. Results are cached, so role
+// changes take effect after at most ttl.
+type permissions struct {
+	src        roleSource
+	guildID    string
+	adminRoles map[string]struct{}
+	modRoles   map[string]struct{}
+	ttl        time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cachedPermission
+}
+
+func newPermissions(src roleSource, guildID string, adminRoles, modRoles map[string]struct{}, ttl time.Duration) *permissions {
+	return &permissions{
+		src:        src,
+		guildID:    guildID,
+		adminRoles: adminRoles,
+		modRoles:   modRoles,
+		ttl:        ttl,
+		cache:      map[string]cachedPermission{},
+	}
+}
+
+// of returns the permission of the user with the given ID.
+func (p *permissions) of(userID string) (permission, error) {
+	p.mutex.Lock()
+	cached, ok := p.cache[userID]
+	p.mutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < p.ttl {
+		return cached.permission, nil
+	}
+
+	roles, err := p.src.memberRoles(p.guildID, userID)
+	if err != nil {
+		return permissionNone, fmt.Errorf("looking up roles of %s: %w", userID, err)
+	}
+
+	perm := permissionNone
+	for _, role := range roles {
+		if _, ok := p.adminRoles[role]; ok {
+			perm = permissionAdmin
+			break
+		}
+		if _, ok := p.modRoles[role]; ok {
+			perm = permissionModerator
+		}
+	}
+
+	p.mutex.Lock()
+	p.cache[userID] = cachedPermission{perm, time.Now()}
+	p.mutex.Unlock()
+
+	return perm, nil
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/sauerbraten/maitred/v2/cmd/discordauth/config"
 	"github.com/sauerbraten/maitred/v2/internal/db"
 	"github.com/sauerbraten/maitred/v2/pkg/auth"
+	"github.com/sauerbraten/maitred/v2/pkg/ping"
 	"github.com/sauerbraten/maitred/v2/pkg/protocol"
 )
 
@@ -16,25 +24,40 @@ type Server struct {
 
 	db *db.Database
 
+	// who may run moderator and admin commands
+	perms *permissions
+
+	// nil if auth events are not recorded
+	authEvents *db.AuthEventWriter
+
+	// registered game servers, for the server browser
+	gameServers *gameServers
+
+	// connected game servers
+	conns *connections
+
+	// nil if the frontend can't send notifications
+	notifier notifier
+
+	// Discord users whose legacy accounts were already claimed
+	claimedMutex sync.Mutex
+	claimed      map[string]struct{}
+
 	// shared
 	stop <-chan struct{}
 }
 
-func newServer(listenAddr *net.TCPAddr, db *db.Database, stop <-chan struct{}) *Server {
-	s := &Server{
-		listenAddr: listenAddr,
-		db:         db,
-		stop:       stop,
+func newServer(listenAddr *net.TCPAddr, db *db.Database, perms *permissions, authEvents *db.AuthEventWriter, gameServers *gameServers, stop <-chan struct{}) *Server {
+	return &Server{
+		listenAddr:  listenAddr,
+		db:          db,
+		perms:       perms,
+		authEvents:  authEvents,
+		gameServers: gameServers,
+		conns:       newConnections(),
+		claimed:     map[string]struct{}{},
+		stop:        stop,
 	}
-
-	stopDiscord := startDiscord(s)
-
-	go func() {
-		<-stop
-		stopDiscord()
-	}()
-
-	return s
 }
 
 func (s *Server) Listen() {
@@ -43,6 +66,9 @@ func (s *Server) Listen() {
 		log.Printf("error starting to listen on %s: %v", s.listenAddr, err)
 	}
 
+	s.pushGBans()
+	go s.expireGBans()
+
 	for {
 		tcpConn, err := listener.AcceptTCP()
 		if err != nil {
@@ -52,50 +78,309 @@ func (s *Server) Listen() {
 		conn := protocol.NewConn(nil)
 		conn.Start(tcpConn)
 
-		go newHandler(conn, s.stop, s.getPublicKey, s.updateUserLastAuthed).run()
+		addr := tcpConn.RemoteAddr().String()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		recordAuthEvent := func(name string, reqID uint32, outcome string, requestedAt time.Time) {
+			s.recordAuthEvent(host, name, reqID, outcome, requestedAt)
+		}
+		authenticated := func(name string, keyID int64) {
+			s.updateUserLastAuthed(name, keyID)
+			s.checkNewServer(name, host)
+		}
+
+		go newHandler(conn, s.stop, config.ChallengeLifetime, config.MaxPendingChallenges, s.getPublicKeys, s.isNameBanned, authenticated, recordAuthEvent, s.gameServers, s.pingGameServer, s.conns).run()
 	}
 }
 
-func (s *Server) addUser(name, pubkey string, override bool) error {
+func (s *Server) addUser(discordID, name, pubkey string, override bool) (string, error) {
 	// let's ensure we don't process garbage
+	_, err := auth.ParsePublicKey(pubkey)
+	if err != nil {
+		return "", fmt.Errorf("parsing public key: %w", err)
+	}
+	// the name only matters if a new account is created
+	_, err = s.db.UserName(discordID)
+	if errors.As(err, new(db.UserNotFoundError)) {
+		err = validateAuthName(name)
+		if err != nil {
+			return "", err
+		}
+	}
+	return s.db.AddUser(discordID, name, pubkey, override)
+}
+
+func (s *Server) renameUser(discordID, newName string) error {
+	err := validateAuthName(newName)
+	if err != nil {
+		return err
+	}
+	return s.db.RenameUser(discordID, newName)
+}
+
+func (s *Server) userName(discordID string) (string, error) {
+	return s.db.UserName(discordID)
+}
+
+// claimLegacyAccount links an account or ban stored under u's username#discriminator to u's ID. Legacy accounts can't
+// be created anymore, so this only needs to happen the first time u shows up.
+func (s *Server) claimLegacyAccount(u user) {
+	s.claimedMutex.Lock()
+	defer s.claimedMutex.Unlock()
+
+	if _, ok := s.claimed[u.ID]; ok {
+		return
+	}
+	err := s.db.ClaimLegacyAccount(u.ID, u.legacyNames()...)
+	if err != nil {
+		log.Printf("claiming legacy account of %s: %v", u, err)
+		return
+	}
+	s.claimed[u.ID] = struct{}{}
+}
+
+func (s *Server) delUser(discordID string) error {
+	return s.db.DelUser(discordID)
+}
+
+// banUser bans or suspends target. A duration of 0 means the ban does not expire.
+func (s *Server) banUser(target, issuer user, reason string, duration time.Duration, suspension bool) error {
+	ban := db.Ban{
+		DiscordID:  sql.NullString{String: target.ID, Valid: true},
+		Name:       target.String(),
+		Reason:     reason,
+		IssuerID:   issuer.ID,
+		IssuerName: issuer.String(),
+		Suspension: suspension,
+	}
+	if duration > 0 {
+		ban.ExpiresAt = sql.NullInt64{Int64: time.Now().Add(duration).Unix(), Valid: true}
+	}
+	return s.db.AddBan(ban)
+}
+
+func (s *Server) addGBan(prefix netip.Prefix, issuer user, reason string, duration time.Duration) error {
+	gban := db.GBan{
+		Prefix:     prefix.String(),
+		Reason:     reason,
+		IssuerID:   issuer.ID,
+		IssuerName: issuer.String(),
+	}
+	if duration > 0 {
+		gban.ExpiresAt = sql.NullInt64{Int64: time.Now().Add(duration).Unix(), Valid: true}
+	}
+	err := s.db.AddGBan(gban)
+	if err != nil {
+		return err
+	}
+	s.pushGBans()
+	return nil
+}
+
+func (s *Server) getGBans() ([]db.GBan, error) {
+	return s.db.GetGBans()
+}
+
+func (s *Server) delGBan(prefix netip.Prefix) error {
+	err := s.db.DelGBan(prefix.String())
+	if err != nil {
+		return err
+	}
+	s.pushGBans()
+	return nil
+}
+
+// pushGBans sends the current gban list to all connected game servers, if it changed.
+func (s *Server) pushGBans() {
+	gbans, err := s.db.GetGBans()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	args := []string{}
+	for _, gban := range gbans {
+		prefix, err := netip.ParsePrefix(gban.Prefix)
+		if err != nil {
+			log.Printf("skipping invalid gban '%s': %v", gban.Prefix, err)
+			continue
+		}
+		if arg, ok := gbanArg(prefix); ok {
+			args = append(args, arg)
+		}
+	}
+	s.conns.setGBans(args)
+}
+
+// expireGBans regularly updates the gban lists of the game servers, so expired gbans are removed.
+func (s *Server) expireGBans() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.pushGBans()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// kickAccount asks the game servers the account of the Discord user with the given ID was used on to kick the players
+// using it, and returns how many game servers were asked.
+func (s *Server) kickAccount(discordID, reason string) int {
+	n := s.conns.kick(discordID, reason)
+	if n > 0 {
+		log.Printf("asked %d game server(s) to kick the account of %s", n, discordID)
+	}
+	return n
+}
+
+func (s *Server) isNameBanned(name string) (bool, error) {
+	return s.db.IsNameBanned(name)
+}
+
+func (s *Server) getBans() ([]db.Ban, error) {
+	return s.db.GetBans()
+}
+
+func (s *Server) getBan(discordID string) (*db.Ban, error) {
+	return s.db.GetBan(discordID)
+}
+
+func (s *Server) unbanUser(discordID string) error {
+	return s.db.DelBan(discordID)
+}
+
+// maxKeysPerUser limits how many keys a user can add besides the one they registered with.
+const maxKeysPerUser = 10
+
+func (s *Server) addKey(name, label, pubkey string) error {
 	_, err := auth.ParsePublicKey(pubkey)
 	if err != nil {
 		return fmt.Errorf("parsing public key: %w", err)
 	}
-	return s.db.AddUser(name, pubkey, override)
+	keys, err := s.db.GetKeys(name)
+	if err != nil && !errors.As(err, new(db.UserNotFoundError)) {
+		return err
+	}
+	if len(keys) >= maxKeysPerUser {
+		return fmt.Errorf("you can't have more than %d keys", maxKeysPerUser)
+	}
+	return s.db.AddKey(name, label, pubkey)
 }
 
-func (s *Server) delUser(name string) error {
-	return s.db.DelUser(name)
+func (s *Server) replaceKey(name, label, pubkey string, grace time.Duration) error {
+	_, err := auth.ParsePublicKey(pubkey)
+	if err != nil {
+		return fmt.Errorf("parsing public key: %w", err)
+	}
+	return s.db.ReplaceKey(name, label, pubkey, grace)
 }
 
-func (s *Server) banUser(name string) error {
-	return s.db.AddBan(name)
+func (s *Server) revokeKey(name, label string) error {
+	return s.db.RevokeKey(name, label)
 }
 
-func (s *Server) isBanned(name string) (bool, error) {
-	return s.db.IsBanned(name)
+func (s *Server) revokeOtherKeys(name string) (int64, error) {
+	return s.db.RevokeOtherKeys(name)
 }
 
-func (s *Server) unbanUser(name string) error {
-	return s.db.DelBan(name)
+func (s *Server) getKeys(name string) ([]db.Key, error) {
+	return s.db.GetKeys(name)
 }
 
-func (s *Server) getPublicKey(name string) (*auth.PublicKey, bool) {
-	pubkey, err := s.db.GetPublicKey(name)
+func (s *Server) getPublicKeys(name string) (string, []userKey, bool) {
+	discordID, err := s.db.DiscordID(name)
 	if err != nil {
-		return nil, false
+		return "", nil, false
 	}
-	pk, err := auth.ParsePublicKey(pubkey)
+	keys, err := s.db.GetKeys(name)
 	if err != nil {
-		return nil, false
+		return "", nil, false
+	}
+	pubkeys := make([]userKey, 0, len(keys))
+	for _, k := range keys {
+		pk, err := auth.ParsePublicKey(k.PublicKey)
+		if err != nil {
+			log.Printf("skipping invalid key '%s' of %s: %v", k.Label, name, err)
+			continue
+		}
+		pubkeys = append(pubkeys, userKey{id: k.ID, pubkey: pk})
+	}
+	return discordID, pubkeys, len(pubkeys) > 0
+}
+
+func (s *Server) updateUserLastAuthed(name string, keyID int64) {
+	err := s.db.UpdateUserLastAuthed(name, keyID)
+	if err != nil {
+		log.Println(err)
 	}
-	return &pk, true
 }
 
-func (s *Server) updateUserLastAuthed(name string) {
-	err := s.db.UpdateUserLastAuthed(name)
+// how many new game servers a notification lists at most, to keep it readable and within Discord's message size limit
+const maxNotifiedServers = 10
+
+// checkNewServer records that the account name was used on the game server at addr, and notifies its owner about the
+// game servers it was never used on before. Servers not reported because of the rate limit are included in the next
+// notification, which is sent on a later authentication.
+func (s *Server) checkNewServer(name, addr string) {
+	_, err := s.db.AddUserServer(name, addr)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if s.notifier == nil {
+		return
+	}
+
+	discordID, servers, err := s.db.ClaimNotification(name, config.NotificationInterval)
 	if err != nil {
 		log.Println(err)
+		return
+	}
+	if len(servers) == 0 {
+		return
+	}
+
+	listed := "`" + strings.Join(servers[:min(len(servers), maxNotifiedServers)], "`, `") + "`"
+	if len(servers) > maxNotifiedServers {
+		listed += fmt.Sprintf(" and %d more", len(servers)-maxNotifiedServers)
+	}
+	content := fmt.Sprintf(":bell: Your account **%s** was used on game servers it was never used on before: %s (last login at %s).\n"+
+		"If that wasn't you, press the button below to suspend your account right away. You can turn these notifications off with `notifications off`.",
+		name, listed, time.Now().UTC().Format("2006-01-02 15:04 MST"))
+	// the command of a button is limited to 100 characters by Discord, so it can't list the servers
+	err = s.notifier.notify(discordID, content, button{label: "This wasn't me", command: "wasntme"})
+	if err != nil {
+		log.Printf("notifying %s about new servers %v: %v", name, servers, err)
+		err = s.db.UnclaimNotification(name, servers)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (s *Server) setNotifyNewServers(discordID string, notify bool) error {
+	return s.db.SetNotifyNewServers(discordID, notify)
+}
+
+func (s *Server) pingGameServer(addr netip.AddrPort) error {
+	return ping.Ping(addr, config.PingTimeout)
+}
+
+func (s *Server) recordAuthEvent(addr, name string, reqID uint32, outcome string, requestedAt time.Time) {
+	if s.authEvents == nil {
+		return
 	}
+	s.authEvents.Add(db.AuthEvent{
+		Name:        name,
+		ServerAddr:  addr,
+		RequestID:   reqID,
+		Outcome:     outcome,
+		RequestedAt: requestedAt.Unix(),
+		CreatedAt:   time.Now().Unix(),
+	})
 }
@@ -4,7 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sauerbraten/maitred/v2/pkg/auth"
 	"github.com/sauerbraten/maitred/v2/pkg/protocol"
@@ -13,51 +17,145 @@ import (
 // pending holds the data we need to remember between
 // generating a challenge and checking the response.
 type pending struct {
-	name     string
-	solution string
+	name      string
+	discordID string   // of the account owner; empty for accounts from before Discord IDs were stored
+	solutions []string // one per key in keyIDs
+	keyIDs    []int64
+	createdAt time.Time
 }
 
+// userKey is one of the public keys a user can authenticate with.
+type userKey struct {
+	id     int64
+	pubkey auth.PublicKey
+}
+
+var (
+	errUserNotFound             = errors.New("user not found")
+	errUserBanned               = errors.New("user is banned")
+	errTooManyPendingChallenges = errors.New("too many pending challenges")
+)
+
 type handler struct {
 	*protocol.Conn
 	stop <-chan struct{}
 
-	pendingChallenges map[uint32]pending
+	pendingChallenges    map[uint32]pending
+	challengeLifetime    time.Duration
+	maxPendingChallenges int
+
+	keysByName           func(name string) (discordID string, keys []userKey, ok bool)
+	isBanned             func(name string) (bool, error)
+	updateUserLastAuthed func(name string, keyID int64)
+	recordAuthEvent      func(name string, reqID uint32, outcome string, requestedAt time.Time)
+
+	gameServers *gameServers
+	pingServer  func(addr netip.AddrPort) error
+	pinging     bool            // a game server registration is waiting for the ping result
+	pingResults chan pingResult // from the goroutine pinging the game server
+	done        bool            // set when the connection is closed after answering a client's request
+	finished    chan struct{}   // closed when the handler stops
+
+	conns        *connections
+	isGameServer bool          // set once the connection sent a game server message, as opposed to a client's list request
+	gbanUpdates  chan []string // gban lists to send to the game server
+	kicks        chan kick     // players to kick from the game server
+}
+
+// kick asks a game server to kick the players authenticated as name.
+type kick struct {
+	name, reason string
+}
+
+// how many kicks can be waiting to be sent to a game server
+const maxPendingKicks = 32
 
-	pubkeyByName         func(name string) (*auth.PublicKey, bool)
-	updateUserLastAuthed func(name string)
+// pingResult is the outcome of pinging a registering game server.
+type pingResult struct {
+	addr netip.AddrPort
+	err  error
 }
 
-func newHandler(conn *protocol.Conn, stop <-chan struct{}, pubkeyByName func(name string) (*auth.PublicKey, bool), updateUserLastAuthed func(name string)) *handler {
+func newHandler(
+	conn *protocol.Conn,
+	stop <-chan struct{},
+	challengeLifetime time.Duration,
+	maxPendingChallenges int,
+	keysByName func(name string) (discordID string, keys []userKey, ok bool),
+	isBanned func(name string) (bool, error),
+	updateUserLastAuthed func(name string, keyID int64),
+	recordAuthEvent func(name string, reqID uint32, outcome string, requestedAt time.Time),
+	gameServers *gameServers,
+	pingServer func(addr netip.AddrPort) error,
+	conns *connections,
+) *handler {
 	return &handler{
 		Conn: conn,
 		stop: stop,
 
 		pendingChallenges:    map[uint32]pending{},
-		pubkeyByName:         pubkeyByName,
+		challengeLifetime:    challengeLifetime,
+		maxPendingChallenges: maxPendingChallenges,
+		keysByName:           keysByName,
+		isBanned:             isBanned,
 		updateUserLastAuthed: updateUserLastAuthed,
+		recordAuthEvent:      recordAuthEvent,
+		gameServers:          gameServers,
+		pingServer:           pingServer,
+		pingResults:          make(chan pingResult),
+		finished:             make(chan struct{}),
+		conns:                conns,
+		gbanUpdates:          make(chan []string, 1),
+		kicks:                make(chan kick, maxPendingKicks),
 	}
 }
 
 func (h *handler) generateChallenge(reqID uint32, name string) (challenge string, err error) {
-	pubkey, ok := h.pubkeyByName(name)
+	if len(h.pendingChallenges) >= h.maxPendingChallenges {
+		return "", fmt.Errorf("%w (%d)", errTooManyPendingChallenges, len(h.pendingChallenges))
+	}
+
+	discordID, keys, ok := h.keysByName(name)
 	if !ok {
-		return "", errors.New("user not found")
+		return "", errUserNotFound
+	}
+
+	banned, err := h.isBanned(name)
+	if err != nil {
+		return "", fmt.Errorf("could not check if %s is banned: %v", name, err)
+	}
+	if banned {
+		return "", errUserBanned
 	}
 
-	challenge, solution, err := auth.GenerateChallenge(*pubkey)
+	pubkeys := make([]auth.PublicKey, len(keys))
+	keyIDs := make([]int64, len(keys))
+	for i, k := range keys {
+		pubkeys[i], keyIDs[i] = k.pubkey, k.id
+	}
+
+	challenge, solutions, err := auth.GenerateChallengeForKeys(pubkeys...)
 	if err != nil {
-		return "", fmt.Errorf("could not generate challenge using pubkey %s of %s: %v", pubkey, name, err)
+		return "", fmt.Errorf("could not generate challenge using %d keys of %s: %v", len(keys), name, err)
 	}
 
 	h.pendingChallenges[reqID] = pending{
-		name:     name,
-		solution: solution,
+		name:      name,
+		discordID: discordID,
+		solutions: solutions,
+		keyIDs:    keyIDs,
+		createdAt: time.Now(),
 	}
 
 	return challenge, nil
 }
 
 func (h *handler) run() {
+	sweep := time.NewTicker(h.challengeLifetime / 2)
+	defer sweep.Stop()
+	defer close(h.finished)
+	defer h.conns.remove(h)
+
 	for {
 		select {
 		case msg, ok := <-h.Incoming():
@@ -66,6 +164,20 @@ func (h *handler) run() {
 				return
 			}
 			h.handle(msg)
+			if h.done {
+				return
+			}
+		case gbans := <-h.gbanUpdates:
+			h.sendGBans(gbans)
+		case k := <-h.kicks:
+			h.sendKick(k)
+		case res := <-h.pingResults:
+			h.completeRegistration(res)
+		case <-sweep.C:
+			h.failExpiredChallenges()
+		case <-h.Done():
+			log.Println("connection to", h.RemoteAddr(), "closed")
+			return
 		case <-h.stop:
 			log.Println("closing connection to", h.RemoteAddr())
 			h.Close()
@@ -74,6 +186,22 @@ func (h *handler) run() {
 	}
 }
 
+func (h *handler) expired(req pending) bool {
+	return time.Since(req.createdAt) > h.challengeLifetime
+}
+
+// failExpiredChallenges drops challenges that were not answered in time and tells the game server the requests failed.
+func (h *handler) failExpiredChallenges() {
+	for reqID, req := range h.pendingChallenges {
+		if h.expired(req) {
+			h.Send("%s %d", protocol.FailAuth, reqID)
+			log.Println("request", reqID, "by", req.name, "expired")
+			h.result(reqID, req.name, resultExpired, req.createdAt)
+			delete(h.pendingChallenges, reqID)
+		}
+	}
+}
+
 func (h *handler) handle(msg string) {
 	if msg == "" {
 		log.Printf("server %s sent empty message", h.RemoteAddr())
@@ -81,15 +209,26 @@ func (h *handler) handle(msg string) {
 		return
 	}
 
-	cmd := strings.Split(msg, " ")[0]
-	if len(cmd) >= len(msg) {
+	cmd, args, _ := strings.Cut(msg, " ")
+	if args == "" && cmd != protocol.List {
 		log.Printf("server %s sent message without arguments", h.RemoteAddr())
 		h.Close()
 		return
 	}
-	args := msg[len(cmd)+1:]
+
+	if !h.isGameServer && cmd != protocol.List {
+		h.isGameServer = true
+		h.conns.add(h)
+		h.sendGBans(<-h.gbanUpdates) // queued by add
+	}
 
 	switch cmd {
+	case protocol.RegServ:
+		h.handleRegServ(args)
+
+	case protocol.List:
+		h.handleList()
+
 	case protocol.ReqAuth:
 		h.handleReqAuth(args)
 
@@ -115,10 +254,24 @@ func (h *handler) handleReqAuth(args string) {
 		}
 
 		log.Printf("generating challenge for '%s' (request %d)", name, reqID)
+		requestedAt := time.Now()
 
 		challenge, err := h.generateChallenge(reqID, name)
 		if err != nil {
-			log.Printf("could not generate challenge for request %d (%s): %v", reqID, name, err)
+			switch {
+			case errors.Is(err, errUserBanned):
+				log.Printf("blocked request %d by %s: user is banned or suspended", reqID, name)
+				h.result(reqID, name, resultBanned, requestedAt)
+			case errors.Is(err, errUserNotFound):
+				log.Printf("request %d failed: no user named %s", reqID, name)
+				h.result(reqID, name, resultUnknownUser, requestedAt)
+			case errors.Is(err, errTooManyPendingChallenges):
+				log.Printf("refused request %d by %s: %v", reqID, name, err)
+				h.result(reqID, name, resultTooManyPending, requestedAt)
+			default:
+				log.Printf("could not generate challenge for request %d (%s): %v", reqID, name, err)
+				h.result(reqID, name, resultError, requestedAt)
+			}
 			h.Send("%s %d", protocol.FailAuth, reqID)
 			return
 		}
@@ -139,16 +292,148 @@ func (h *handler) handleConfAuth(args string) {
 		}
 
 		req, ok := h.pendingChallenges[reqID]
+		delete(h.pendingChallenges, reqID)
 
-		if ok && answer == req.solution {
-			go h.updateUserLastAuthed(req.name)
-			h.Send("%s %d", protocol.SuccAuth, reqID)
-			log.Println("request", reqID, "by", req.name, "completed successfully")
-		} else {
+		switch {
+		case !ok:
+			h.Send("%s %d", protocol.FailAuth, reqID)
+			log.Println("request", reqID, "failed: no such request")
+			h.result(reqID, "", resultUnknownRequest, time.Now())
+		case h.expired(req):
 			h.Send("%s %d", protocol.FailAuth, reqID)
-			log.Println("request", reqID, "by", req.name, "failed")
+			log.Println("request", reqID, "by", req.name, "expired")
+			h.result(reqID, req.name, resultExpired, req.createdAt)
+		default:
+			keyID, correct, err := verifyAnswer(answer, req)
+			switch {
+			case err != nil:
+				h.Send("%s %d", protocol.FailAuth, reqID)
+				log.Printf("request %d by %s failed: malformed answer '%s'", reqID, req.name, answer)
+				h.result(reqID, req.name, resultMalformedAnswer, req.createdAt)
+			case !correct:
+				h.Send("%s %d", protocol.FailAuth, reqID)
+				log.Println("request", reqID, "by", req.name, "failed: wrong answer")
+				h.result(reqID, req.name, resultWrongAnswer, req.createdAt)
+			default:
+				go h.updateUserLastAuthed(req.name, keyID)
+				h.conns.authenticated(h, req.discordID, req.name)
+				h.Send("%s %d", protocol.SuccAuth, reqID)
+				log.Println("request", reqID, "by", req.name, "completed successfully using key", keyID)
+				h.result(reqID, req.name, resultSuccess, req.createdAt)
+			}
 		}
+	}
+}
 
-		delete(h.pendingChallenges, reqID)
+// handleRegServ starts pinging the game server so it can be added to the server list. args is the port the game
+// server listens on.
+func (h *handler) handleRegServ(args string) {
+	port, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || port <= 0 || port >= 0xFFFF { // the info port (port+1) has to be valid, too
+		log.Printf("malformed %s message from game server %s: '%s'", protocol.RegServ, h.RemoteAddr(), args)
+		h.Send("%s invalid port", protocol.FailReg)
+		return
+	}
+
+	if h.pinging {
+		log.Printf("ignoring %s message from game server %s: already registering", protocol.RegServ, h.RemoteAddr())
+		return
+	}
+	h.pinging = true
+
+	addr := netip.AddrPortFrom(h.RemoteAddr().(*net.TCPAddr).AddrPort().Addr().Unmap(), uint16(port))
+	go func() {
+		res := pingResult{addr: addr, err: h.pingServer(addr)}
+		select {
+		case h.pingResults <- res:
+		case <-h.finished:
+		}
+	}()
+}
+
+// completeRegistration adds the game server to the server list if it answered the ping.
+func (h *handler) completeRegistration(res pingResult) {
+	h.pinging = false
+	if res.err != nil {
+		log.Printf("registration of game server %s failed: %v", res.addr, res.err)
+		gameServerRegistrations.Add("failed", 1)
+		h.Send("%s failed pinging server", protocol.FailReg)
+		return
+	}
+	h.gameServers.register(res.addr)
+	log.Println("registered game server", res.addr)
+	gameServerRegistrations.Add("succeeded", 1)
+	h.Send("%s", protocol.SuccReg)
+}
+
+// handleList sends the server list to a client and closes the connection, as clients read the list until the
+// connection is closed.
+func (h *handler) handleList() {
+	servers := h.gameServers.list()
+	for _, addr := range servers {
+		h.Send("%s %s %d", protocol.AddServer, addr.Addr(), addr.Port())
+	}
+	log.Printf("sent list of %d game servers to %s", len(servers), h.RemoteAddr())
+	h.CloseWhenSent()
+	h.done = true
+}
+
+// pushGBans queues the gban list to be sent to the game server, replacing a list that wasn't sent yet. Only one
+// goroutine may call pushGBans at a time.
+func (h *handler) pushGBans(gbans []string) {
+	select {
+	case <-h.gbanUpdates:
+	default:
+	}
+	h.gbanUpdates <- gbans
+}
+
+// sendGBans replaces the game server's gban list.
+func (h *handler) sendGBans(gbans []string) {
+	h.Send("%s", protocol.ClearBans)
+	for _, gban := range gbans {
+		h.Send("%s %s", protocol.AddBan, gban)
+	}
+	log.Printf("sent %d gbans to %s", len(gbans), h.RemoteAddr())
+}
+
+// pushKick queues k to be sent to the game server. It returns false if too many kicks are queued already.
+func (h *handler) pushKick(k kick) bool {
+	select {
+	case h.kicks <- k:
+		return true
+	default:
+		log.Printf("dropping kick of %s on %s: too many pending kicks", k.name, h.RemoteAddr())
+		return false
 	}
 }
+
+func (h *handler) sendKick(k kick) {
+	if k.reason == "" {
+		h.Send("%s %s", protocol.Kick, k.name)
+	} else {
+		h.Send("%s %s %s", protocol.Kick, k.name, k.reason)
+	}
+	log.Printf("asked %s to kick %s", h.RemoteAddr(), k.name)
+}
+
+// verifyAnswer checks answer against the solutions for all of the user's keys and returns the ID of the key that was
+// used. All solutions are checked, so the time taken does not depend on which key matched.
+func verifyAnswer(answer string, req pending) (keyID int64, correct bool, err error) {
+	for i, solution := range req.solutions {
+		ok, err := auth.VerifyAnswer(answer, solution)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok && !correct {
+			keyID, correct = req.keyIDs[i], true
+		}
+	}
+	return
+}
+
+// result counts the outcome of request reqID by name and records it in the auth event log.
+func (h *handler) result(reqID uint32, name, outcome string, requestedAt time.Time) {
+	authResults.Add(outcome, 1)
+	h.recordAuthEvent(name, reqID, outcome, requestedAt)
+}
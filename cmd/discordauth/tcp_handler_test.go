@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/pkg/auth"
+	"github.com/sauerbraten/maitred/v2/pkg/ping"
+	"github.com/sauerbraten/maitred/v2/pkg/ping/pingtest"
+	"github.com/sauerbraten/maitred/v2/pkg/protocol"
+)
+
+func TestGenerateChallenge(t *testing.T) {
+	priv, pub, err := auth.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	keys := map[string][]userKey{
+		"player": {{id: 1, pubkey: pub}},
+		"banned": {{id: 2, pubkey: pub}},
+	}
+	h := newHandler(nil, nil, time.Minute, 10,
+		func(name string) (string, []userKey, bool) {
+			k, ok := keys[name]
+			return "id-" + name, k, ok
+		},
+		func(name string) (bool, error) { return name == "banned", nil },
+		func(string, int64) {},
+		func(string, uint32, string, time.Time) {},
+		newGameServers(time.Hour),
+		func(netip.AddrPort) error { return nil },
+		newConnections(),
+	)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"player", nil},
+		{"banned", errUserBanned},
+		{"nobody", errUserNotFound},
+	}
+
+	for i, test := range tests {
+		chal, err := h.generateChallenge(uint32(i), test.name)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := h.pendingChallenges[uint32(i)]; ok {
+				t.Errorf("%s: challenge is pending although the request failed", test.name)
+			}
+			continue
+		}
+
+		answ, err := auth.Solve(chal, priv)
+		if err != nil {
+			t.Fatalf("solving challenge: %v", err)
+		}
+		keyID, ok, err := verifyAnswer(answ, h.pendingChallenges[uint32(i)])
+		if err != nil || !ok || keyID != 1 {
+			t.Errorf("%s: expected answer to be accepted for key 1, got key %d, %v, %v", test.name, keyID, ok, err)
+		}
+	}
+
+	h.maxPendingChallenges = len(h.pendingChallenges)
+	_, err = h.generateChallenge(uint32(len(tests)), "player")
+	if !errors.Is(err, errTooManyPendingChallenges) {
+		t.Errorf("expected error %v, got %v", errTooManyPendingChallenges, err)
+	}
+}
+
+// listenForTest accepts connections on a local port and runs a handler for each of them. Users are looked up in keys;
+// the Discord ID of a user is their name prefixed with "id-".
+func listenForTest(t *testing.T, gameServers *gameServers, conns *connections, keys map[string][]userKey) *net.TCPAddr {
+	t.Helper()
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	go func() {
+		for {
+			tcpConn, err := l.AcceptTCP()
+			if err != nil {
+				return
+			}
+			conn := protocol.NewConn(nil)
+			conn.Start(tcpConn)
+			go newHandler(conn, stop, time.Minute, 10,
+				func(name string) (string, []userKey, bool) {
+					k, ok := keys[name]
+					return "id-" + name, k, ok
+				},
+				func(string) (bool, error) { return false, nil },
+				func(string, int64) {},
+				func(string, uint32, string, time.Time) {},
+				gameServers,
+				func(addr netip.AddrPort) error { return ping.Ping(addr, 300*time.Millisecond) },
+				conns,
+			).run()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+// dialForTest connects to addr, sends the given lines, and returns a reader for the replies.
+func dialForTest(t *testing.T, addr *net.TCPAddr, lines ...string) *bufio.Reader {
+	t.Helper()
+
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, line := range lines {
+		_, err = conn.Write([]byte(line + "\n"))
+		if err != nil {
+			t.Fatalf("sending '%s': %v", line, err)
+		}
+	}
+	return bufio.NewReader(conn)
+}
+
+func expectLine(t *testing.T, r *bufio.Reader, expected string) {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected '%s', got error %v", expected, err)
+	}
+	if line != expected+"\n" {
+		t.Fatalf("expected '%s', got '%s'", expected, line[:len(line)-1])
+	}
+}
+
+func TestServerList(t *testing.T) {
+	addr := listenForTest(t, newGameServers(time.Hour), newConnections(), nil)
+
+	r := dialForTest(t, addr, "list")
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expected empty list and closed connection, got %v", err)
+	}
+
+	servers := make([]*pingtest.Responder, 2)
+	for i := range servers {
+		var err error
+		servers[i], err = pingtest.NewResponder(nil)
+		if err != nil {
+			t.Fatalf("starting fake game server: %v", err)
+		}
+		defer servers[i].Close()
+	}
+	offline, err := pingtest.NewResponder(nil)
+	if err != nil {
+		t.Fatalf("starting fake game server: %v", err)
+	}
+	offline.Close()
+
+	r = dialForTest(t, addr, "regserv 0", "regserv 65535", "regserv x", fmt.Sprintf("regserv %d", servers[0].Addr().Port()))
+	expectLine(t, r, "cleargbans")
+	for range 3 {
+		expectLine(t, r, "failreg invalid port")
+	}
+	expectLine(t, r, "succreg")
+
+	r = dialForTest(t, addr, fmt.Sprintf("regserv %d", offline.Addr().Port()))
+	expectLine(t, r, "cleargbans")
+	expectLine(t, r, "failreg failed pinging server")
+
+	r = dialForTest(t, addr, fmt.Sprintf("regserv %d", servers[1].Addr().Port()))
+	expectLine(t, r, "cleargbans")
+	expectLine(t, r, "succreg")
+
+	ports := []uint16{servers[0].Addr().Port(), servers[1].Addr().Port()}
+	slices.Sort(ports)
+
+	r = dialForTest(t, addr, "list")
+	for _, port := range ports {
+		expectLine(t, r, fmt.Sprintf("addserver 127.0.0.1 %d", port))
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expected connection to be closed after list, got %v", err)
+	}
+}
+
+func TestGameServersExpiry(t *testing.T) {
+	g := newGameServers(time.Minute)
+	g.register(netip.MustParseAddrPort("10.0.0.1:28785"))
+	g.register(netip.MustParseAddrPort("10.0.0.2:28785"))
+	g.servers[netip.MustParseAddrPort("10.0.0.1:28785")] = time.Now().Add(-2 * time.Minute)
+
+	list := g.list()
+	if len(list) != 1 || list[0] != netip.MustParseAddrPort("10.0.0.2:28785") {
+		t.Errorf("expected only 10.0.0.2:28785 to be listed, got %v", list)
+	}
+	if len(g.servers) != 1 {
+		t.Errorf("expired server was not removed: %v", g.servers)
+	}
+}
+
+func TestGBanUpdates(t *testing.T) {
+	conns := newConnections()
+	conns.setGBans([]string{"1.2.3.0/24"})
+	addr := listenForTest(t, newGameServers(time.Hour), conns, nil)
+
+	// clients asking for the server list don't get gbans
+	r := dialForTest(t, addr, "list")
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expected empty list and closed connection, got %v", err)
+	}
+
+	servers := []*bufio.Reader{
+		dialForTest(t, addr, "reqauth 1 nobody"),
+		dialForTest(t, addr, "reqauth 1 nobody"),
+	}
+	for _, r := range servers {
+		expectLine(t, r, "cleargbans")
+		expectLine(t, r, "addgban 1.2.3.0/24")
+		expectLine(t, r, "failauth 1")
+	}
+
+	conns.setGBans([]string{"1.2.3.0/24", "5.6.7.8"})
+	for _, r := range servers {
+		expectLine(t, r, "cleargbans")
+		expectLine(t, r, "addgban 1.2.3.0/24")
+		expectLine(t, r, "addgban 5.6.7.8")
+	}
+}
+
+func TestKick(t *testing.T) {
+	priv, pub, err := auth.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+	conns := newConnections()
+	addr := listenForTest(t, newGameServers(time.Hour), conns, map[string][]userKey{"alice": {{id: 1, pubkey: pub}}})
+
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprintln(conn, "reqauth 1 alice")
+	expectLine(t, r, "cleargbans")
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading challenge: %v", err)
+	}
+	var reqID uint32
+	var chal string
+	_, err = fmt.Sscanf(line, "chalauth %d %s", &reqID, &chal)
+	if err != nil {
+		t.Fatalf("parsing challenge '%s': %v", line, err)
+	}
+	answ, err := auth.Solve(chal, priv)
+	if err != nil {
+		t.Fatalf("solving challenge: %v", err)
+	}
+
+	// only game servers the account authenticated on are asked to kick
+	other := dialForTest(t, addr, "reqauth 1 nobody")
+	expectLine(t, other, "cleargbans")
+	expectLine(t, other, "failauth 1")
+
+	if n := conns.kick("id-alice", ""); n != 0 {
+		t.Errorf("kick before authentication reached %d game servers", n)
+	}
+
+	_, err = fmt.Fprintf(conn, "confauth %d %s\n", reqID, answ)
+	if err != nil {
+		t.Fatalf("answering challenge: %v", err)
+	}
+	expectLine(t, r, "succauth 1")
+
+	if n := conns.kick("id-alice", "cheating"); n != 1 {
+		t.Errorf("expected kick to reach 1 game server, reached %d", n)
+	}
+	expectLine(t, r, "kick alice cheating")
+}
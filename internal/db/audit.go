@@ -0,0 +1,39 @@
+package db
+
+import (
+	"fmt"
+)
+
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	ActorID   string `json:"actor_id"`
+	ActorName string `json:"actor_name"`
+	Command   string `json:"command"`
+	Args      string `json:"args"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// AddAuditEntry records that the Discord user with the given ID ran a privileged command.
+func (db *Database) AddAuditEntry(actorID, actorName, command, args string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	_, err := db.Exec("insert into `audit_log` (`actor_id`, `actor_name`, `command`, `args`) values (?, ?, ?, ?)", actorID, actorName, command, args)
+	if err != nil {
+		return fmt.Errorf("db: inserting ('%s', '%s', '%s') into audit_log table: %w", actorID, command, args, err)
+	}
+	return nil
+}
+
+// GetAuditLog returns the most recent limit entries of the audit log, newest first.
+func (db *Database) GetAuditLog(limit int) ([]AuditEntry, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	entries := []AuditEntry{}
+	err := db.Select(&entries, "select * from `audit_log` order by `id` desc limit ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving audit log: %v", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type AuthEvent struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	ServerAddr  string `json:"server_addr"`
+	RequestID   uint32 `json:"request_id"`
+	Outcome     string `json:"outcome"`
+	RequestedAt int64  `json:"requested_at"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// AddAuthEvents stores events in a single transaction.
+func (db *Database) AddAuthEvents(events []AuthEvent) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Preparex("insert into `auth_events` (`name`, `server_addr`, `request_id`, `outcome`, `requested_at`, `created_at`) values (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("db: preparing insert into auth_events table: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		_, err := stmt.Exec(e.Name, e.ServerAddr, e.RequestID, e.Outcome, e.RequestedAt, e.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("db: inserting auth event (%s, %s, %d, %s) into auth_events table: %w", e.Name, e.ServerAddr, e.RequestID, e.Outcome, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAuthEventsByName returns the most recent limit auth events concerning the account name, newest first.
+func (db *Database) GetAuthEventsByName(name string, limit int) ([]AuthEvent, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	events := []AuthEvent{}
+	err := db.Select(&events, "select * from `auth_events` where `name` = ? order by `created_at` desc, `id` desc limit ?", name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving auth events of '%s': %v", name, err)
+	}
+	return events, nil
+}
+
+// GetAuthEventsByServer returns the most recent limit auth events from the game server at addr, newest first.
+func (db *Database) GetAuthEventsByServer(addr string, limit int) ([]AuthEvent, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	events := []AuthEvent{}
+	err := db.Select(&events, "select * from `auth_events` where `server_addr` = ? order by `created_at` desc, `id` desc limit ?", addr, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving auth events from '%s': %v", addr, err)
+	}
+	return events, nil
+}
+
+// CountAuthEventsSince returns how many auth events with the given outcome happened since t, by account name.
+func (db *Database) CountAuthEventsSince(outcome string, t time.Time) (map[string]int, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	rows := []struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}{}
+	err := db.Select(&rows, "select `name`, count(*) as `count` from `auth_events` where `outcome` = ? and `created_at` >= ? group by `name`", outcome, t.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("db: counting '%s' auth events since %v: %v", outcome, t, err)
+	}
+	counts := make(map[string]int, len(rows))
+	for _, r := range rows {
+		counts[r.Name] = r.Count
+	}
+	return counts, nil
+}
+
+// DelAuthEventsBefore deletes auth events older than t and returns how many were deleted.
+func (db *Database) DelAuthEventsBefore(t time.Time) (int64, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("delete from `auth_events` where `created_at` < ?", t.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("db: deleting auth events before %v: %v", t, err)
+	}
+	return res.RowsAffected()
+}
+
+// AuthEventWriter stores auth events in the background, in batches. Events are dropped when they come in faster than
+// they can be written.
+type AuthEventWriter struct {
+	db            *Database
+	events        chan AuthEvent
+	batchSize     int
+	flushInterval time.Duration
+	retention     time.Duration
+	done          chan struct{}
+
+	mutex  sync.Mutex
+	closed bool // events is closed
+}
+
+// NewAuthEventWriter starts writing auth events to db. A batch is written when it has batchSize events, or after
+// flushInterval. Events older than retention are deleted periodically; a retention of 0 keeps them forever.
+func (db *Database) NewAuthEventWriter(batchSize int, flushInterval, retention time.Duration) *AuthEventWriter {
+	w := &AuthEventWriter{
+		db:            db,
+		events:        make(chan AuthEvent, 10*batchSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retention:     retention,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Add queues e for writing. It never blocks. Events added after Close are dropped.
+func (w *AuthEventWriter) Add(e AuthEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		log.Printf("db: auth event writer is closed, dropping event (%s, %s, %d, %s)", e.Name, e.ServerAddr, e.RequestID, e.Outcome)
+		return
+	}
+	select {
+	case w.events <- e:
+	default:
+		log.Printf("db: auth event queue is full, dropping event (%s, %s, %d, %s)", e.Name, e.ServerAddr, e.RequestID, e.Outcome)
+	}
+}
+
+// Close writes all queued events and stops the writer.
+func (w *AuthEventWriter) Close() {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+	w.mutex.Unlock()
+
+	<-w.done
+}
+
+func (w *AuthEventWriter) run() {
+	defer close(w.done)
+
+	flush := time.NewTicker(w.flushInterval)
+	defer flush.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	w.prune()
+
+	batch := make([]AuthEvent, 0, w.batchSize)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := w.db.AddAuthEvents(batch)
+		if err != nil {
+			log.Printf("dropping %d auth events: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e, ok := <-w.events:
+			if !ok {
+				write()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case <-prune.C:
+			w.prune()
+		}
+	}
+}
+
+func (w *AuthEventWriter) prune() {
+	if w.retention <= 0 {
+		return
+	}
+	n, err := w.db.DelAuthEventsBefore(time.Now().Add(-w.retention))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if n > 0 {
+		log.Printf("db: deleted %d auth events older than %v", n, w.retention)
+	}
+}
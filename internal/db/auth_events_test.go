@@ -0,0 +1,61 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthEventWriter(t *testing.T) {
+	t.Chdir("../..") // for the migrations directory
+	db, err := New(filepath.Join(t.TempDir(), "users.sqlite"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour).Unix()
+	err = db.AddAuthEvents([]AuthEvent{{Name: "alice", ServerAddr: "1.2.3.4", Outcome: "success", RequestedAt: old, CreatedAt: old}})
+	if err != nil {
+		t.Fatalf("adding old event: %v", err)
+	}
+
+	w := db.NewAuthEventWriter(2, time.Hour, 24*time.Hour)
+	for i, outcome := range []string{"success", "wrong_answer", "wrong_answer"} {
+		w.Add(AuthEvent{Name: "alice", ServerAddr: "1.2.3.4", RequestID: uint32(i), Outcome: outcome, RequestedAt: now.Unix(), CreatedAt: now.Unix()})
+	}
+	w.Add(AuthEvent{Name: "bob", ServerAddr: "5.6.7.8", Outcome: "banned", RequestedAt: now.Unix(), CreatedAt: now.Unix()})
+	w.Close() // flushes the last, incomplete batch
+
+	// game server connections may still report outcomes while shutting down
+	w.Add(AuthEvent{Name: "alice", ServerAddr: "1.2.3.4", Outcome: "success", RequestedAt: now.Unix(), CreatedAt: now.Unix()})
+	w.Close()
+
+	events, err := db.GetAuthEventsByName("alice", 10)
+	if err != nil {
+		t.Fatalf("retrieving events: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected the old event to be pruned and 3 events to remain, got %d: %+v", len(events), events)
+	}
+	if events[0].RequestID != 2 {
+		t.Errorf("expected newest event first, got request %d", events[0].RequestID)
+	}
+
+	events, err = db.GetAuthEventsByServer("5.6.7.8", 10)
+	if err != nil {
+		t.Fatalf("retrieving events: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "bob" || events[0].Outcome != "banned" {
+		t.Errorf("unexpected events from 5.6.7.8:1234: %+v", events)
+	}
+
+	counts, err := db.CountAuthEventsSince("wrong_answer", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("counting events: %v", err)
+	}
+	if counts["alice"] != 2 || len(counts) != 1 {
+		t.Errorf("expected 2 wrong answers by alice, got %v", counts)
+	}
+}
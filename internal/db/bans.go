@@ -1,44 +1,134 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 )
 
 type Ban struct {
-	Name string `json:"name"`
+	ID         int64          `json:"id"`
+	DiscordID  sql.NullString `json:"discord_id"` // null for bans from before Discord IDs were stored
+	Name       string         `json:"name"`       // Discord handle at the time of the ban, for display purposes
+	Reason     string         `json:"reason"`
+	IssuerID   string         `json:"issuer_id"`
+	IssuerName string         `json:"issuer_name"`
+	CreatedAt  int64          `json:"created_at"`
+	ExpiresAt  sql.NullInt64  `json:"expires_at"`
+	Suspension bool           `json:"suspension"`
 }
 
-func (db *Database) AddBan(name string) error {
+// BanExistsError is returned when adding a ban of a user who already is banned or suspended by someone else.
+type BanExistsError Ban
+
+func (e BanExistsError) Error() string {
+	return fmt.Sprintf("db: %s is already banned by %s", e.Name, e.IssuerName)
+}
+
+// activeBan restricts a query on bans to bans that have not expired
+const activeBan = "(`expires_at` is null or `expires_at` > strftime('%s', 'now'))"
+
+// AddBan bans or suspends the Discord user with ID ban.DiscordID. An existing ban of that user is replaced if it was
+// issued by the same person, by the user themself, or by an unknown issuer; otherwise, a BanExistsError is returned.
+func (db *Database) AddBan(ban Ban) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	_, err := db.Exec("insert into `bans` (`name`) values (?)", name)
+	err := db.delExpiredBans()
+	if err != nil {
+		return err
+	}
+
+	existing := Ban{}
+	err = db.Get(&existing, "select * from `bans` where `discord_id` = ?", ban.DiscordID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("db: checking if '%s' is in bans table: %v", ban.DiscordID.String, err)
+	}
+	// bans from before issuers were recorded have no issuer and can be replaced by anyone
+	if err == nil && existing.IssuerID != "" && existing.IssuerID != ban.IssuerID && existing.IssuerID != existing.DiscordID.String {
+		return BanExistsError(existing)
+	}
+
+	_, err = db.Exec("insert into `bans` (`discord_id`, `name`, `reason`, `issuer_id`, `issuer_name`, `expires_at`, `suspension`) values (?, ?, ?, ?, ?, ?, ?) "+
+		"on conflict (`discord_id`) do update set `name` = excluded.`name`, `reason` = excluded.`reason`, `issuer_id` = excluded.`issuer_id`, `issuer_name` = excluded.`issuer_name`, `created_at` = strftime('%s', 'now'), `expires_at` = excluded.`expires_at`, `suspension` = excluded.`suspension`",
+		ban.DiscordID, ban.Name, ban.Reason, ban.IssuerID, ban.IssuerName, ban.ExpiresAt, ban.Suspension)
 	if err != nil {
-		return fmt.Errorf("db: inserting ('%s') into bans table: %w", name, err)
+		return fmt.Errorf("db: inserting ('%s', '%s') into bans table: %w", ban.DiscordID.String, ban.Name, err)
 	}
 
 	return nil
 }
 
-func (db *Database) IsBanned(name string) (bool, error) {
+func (db *Database) IsBanned(discordID string) (bool, error) {
+	ban, err := db.GetBan(discordID)
+	return ban != nil, err
+}
+
+// GetBan returns the active ban or suspension of the Discord user with the given ID, or nil if there is none.
+func (db *Database) GetBan(discordID string) (*Ban, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ban := new(Ban)
+	err := db.Get(ban, "select * from `bans` where `discord_id` = ? and "+activeBan, discordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db: checking if '%s' is in bans table: %v", discordID, err)
+	}
+	return ban, nil
+}
+
+// IsNameBanned reports whether the account with the given name is banned or suspended. Bans from before Discord IDs
+// were stored are matched by name.
+func (db *Database) IsNameBanned(name string) (bool, error) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
 	count := 0
-	err := db.Get(&count, "select count(*) from `bans` where `name` = ?", name)
+	err := db.Get(&count, "select count(*) from `bans` where "+activeBan+" and "+
+		"(`discord_id` = (select `discord_id` from `users` where `name` = ?) or (`discord_id` is null and `name` = ?))", name, name)
 	if err != nil {
-		return false, fmt.Errorf("db: checking if '%s' is in bans table: %v", name, err)
+		return false, fmt.Errorf("db: checking if '%s' is banned: %v", name, err)
 	}
-	return count == 1, nil
+	return count > 0, nil
 }
 
-func (db *Database) DelBan(name string) error {
+// GetBans returns all active bans and suspensions, newest first.
+func (db *Database) GetBans() ([]Ban, error) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	_, err := db.Exec("delete from `bans` where `name` = ?", name)
+	err := db.delExpiredBans()
+	if err != nil {
+		return nil, err
+	}
+
+	bans := []Ban{}
+	err = db.Select(&bans, "select * from `bans` order by `created_at` desc")
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving bans: %v", err)
+	}
+	return bans, nil
+}
+
+func (db *Database) DelBan(discordID string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	_, err := db.Exec("delete from `bans` where `discord_id` = ?", discordID)
+	if err != nil {
+		return fmt.Errorf("db: deleting '%s' from bans table: %v", discordID, err)
+	}
+	return nil
+}
+
+// delExpiredBans deletes bans that have expired. db.mutex must be held.
+func (db *Database) delExpiredBans() error {
+	_, err := db.Exec("delete from `bans` where not " + activeBan)
 	if err != nil {
-		return fmt.Errorf("db: deleting '%s' from bans table: %v", name, err)
+		return fmt.Errorf("db: deleting expired bans: %v", err)
 	}
 	return nil
 }
@@ -20,19 +20,13 @@ type Database struct {
 }
 
 func New(path string) (*Database, error) {
-	db, err := sqlx.Open("sqlite3", path)
+	db, err := sqlx.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return nil, errors.New("db: opening database: " + err.Error())
 	}
 
 	db.Mapper = reflectx.NewMapperFunc("json", strings.ToLower) // use json struct tags
 
-	_, err = db.Exec("pragma foreign_keys = on")
-	if err != nil {
-		db.Close()
-		return nil, errors.New("db: enabling foreign keys: " + err.Error())
-	}
-
 	err = migrateUp(db.DB)
 	if err != nil {
 		db.Close()
@@ -45,10 +39,18 @@ func New(path string) (*Database, error) {
 		return nil, errors.New("db: enabling WAL mode: " + err.Error())
 	}
 
-	return &Database{
+	d := &Database{
 		mutex: sync.Mutex{},
 		DB:    db,
-	}, nil
+	}
+
+	err = d.fillNameSkeletons()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return d, nil
 }
 
 func migrateUp(db *sql.DB) error {
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GBan bans an IP range from all game servers using this master server.
+type GBan struct {
+	ID         int64         `json:"id"`
+	Prefix     string        `json:"prefix"` // CIDR notation
+	Reason     string        `json:"reason"`
+	IssuerID   string        `json:"issuer_id"`
+	IssuerName string        `json:"issuer_name"`
+	CreatedAt  int64         `json:"created_at"`
+	ExpiresAt  sql.NullInt64 `json:"expires_at"`
+}
+
+type GBanNotFoundError string
+
+func (e GBanNotFoundError) Error() string {
+	return fmt.Sprintf("db: %s is not banned", string(e))
+}
+
+// AddGBan bans the IP range gban.Prefix, replacing any existing ban of the same range.
+func (db *Database) AddGBan(gban GBan) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	err := db.delExpiredGBans()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into `gbans` (`prefix`, `reason`, `issuer_id`, `issuer_name`, `expires_at`) values (?, ?, ?, ?, ?) "+
+		"on conflict (`prefix`) do update set `reason` = excluded.`reason`, `issuer_id` = excluded.`issuer_id`, `issuer_name` = excluded.`issuer_name`, `created_at` = strftime('%s', 'now'), `expires_at` = excluded.`expires_at`",
+		gban.Prefix, gban.Reason, gban.IssuerID, gban.IssuerName, gban.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("db: inserting '%s' into gbans table: %w", gban.Prefix, err)
+	}
+	return nil
+}
+
+// GetGBans returns all active gbans, oldest first.
+func (db *Database) GetGBans() ([]GBan, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	err := db.delExpiredGBans()
+	if err != nil {
+		return nil, err
+	}
+
+	gbans := []GBan{}
+	err = db.Select(&gbans, "select * from `gbans` order by `created_at`, `id`")
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving gbans: %v", err)
+	}
+	return gbans, nil
+}
+
+func (db *Database) DelGBan(prefix string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("delete from `gbans` where `prefix` = ?", prefix)
+	if err != nil {
+		return fmt.Errorf("db: deleting '%s' from gbans table: %v", prefix, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return GBanNotFoundError(prefix)
+	}
+	return nil
+}
+
+// delExpiredGBans deletes gbans that have expired. db.mutex must be held.
+func (db *Database) delExpiredGBans() error {
+	_, err := db.Exec("delete from `gbans` where not " + activeBan)
+	if err != nil {
+		return fmt.Errorf("db: deleting expired gbans: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultKeyLabel is the label of the key a user registers with.
+const DefaultKeyLabel = "default"
+
+type Key struct {
+	ID           int64         `json:"id"`
+	Name         string        `json:"name"`
+	Label        string        `json:"label"`
+	PublicKey    string        `json:"pubkey"`
+	CreatedAt    int64         `json:"created_at"`
+	ExpiresAt    sql.NullInt64 `json:"expires_at"` // set while a replaced key is still accepted
+	LastAuthedAt int64         `json:"last_authed_at"`
+}
+
+type KeyExistsError Key
+
+func (e KeyExistsError) Error() string {
+	return fmt.Sprintf("db: %s already has a key labelled %s (%s)", e.Name, e.Label, e.PublicKey)
+}
+
+type KeyNotFoundError struct {
+	Name, Label string
+}
+
+func (e KeyNotFoundError) Error() string {
+	return fmt.Sprintf("db: %s has no key labelled %s", e.Name, e.Label)
+}
+
+// active restricts a query on user_keys to keys that can be used to authenticate
+const active = "(`expires_at` is null or `expires_at` > strftime('%s', 'now'))"
+
+// AddKey adds another key to an existing user.
+func (db *Database) AddKey(name, label, pubkey string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	err = tx.Get(&count, "select count(*) from `users` where `name` = ?", name)
+	if err != nil {
+		return fmt.Errorf("db: checking if '%s' is in users table: %w", name, err)
+	}
+	if count == 0 {
+		return UserNotFoundError(name)
+	}
+
+	var existing string
+	err = tx.Get(&existing, "select `pubkey` from `user_keys` where `name` = ? and `label` = ? and `expires_at` is null", name, label)
+	if err == nil {
+		return KeyExistsError(Key{Name: name, Label: label, PublicKey: existing})
+	}
+
+	_, err = tx.Exec("insert into `user_keys` (`name`, `label`, `pubkey`) values (?, ?, ?)", name, label, pubkey)
+	if err != nil {
+		return fmt.Errorf("db: inserting ('%s', '%s', '%s') into user_keys table: %w", name, label, pubkey, err)
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceKey replaces the key labelled label with pubkey. For the duration of grace, the old key keeps working.
+func (db *Database) ReplaceKey(name, label, pubkey string, grace time.Duration) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("delete from `user_keys` where `name` = ? and not "+active, name)
+	if err != nil {
+		return fmt.Errorf("db: deleting expired keys of '%s': %w", name, err)
+	}
+
+	var res sql.Result
+	if grace > 0 {
+		res, err = tx.Exec("update `user_keys` set `expires_at` = strftime('%s', 'now') + ? where `name` = ? and `label` = ? and `expires_at` is null", int64(grace.Seconds()), name, label)
+	} else {
+		res, err = tx.Exec("delete from `user_keys` where `name` = ? and `label` = ?", name, label)
+	}
+	if err != nil {
+		return fmt.Errorf("db: retiring key '%s' of '%s': %w", label, name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return KeyNotFoundError{name, label}
+	}
+
+	_, err = tx.Exec("insert into `user_keys` (`name`, `label`, `pubkey`) values (?, ?, ?)", name, label, pubkey)
+	if err != nil {
+		return fmt.Errorf("db: inserting ('%s', '%s', '%s') into user_keys table: %w", name, label, pubkey, err)
+	}
+
+	return tx.Commit()
+}
+
+// RevokeKey deletes the key labelled label, including any replaced key still in its grace period.
+func (db *Database) RevokeKey(name, label string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("delete from `user_keys` where `name` = ? and `label` = ?", name, label)
+	if err != nil {
+		return fmt.Errorf("db: deleting key '%s' of '%s': %v", label, name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return KeyNotFoundError{name, label}
+	}
+	return nil
+}
+
+// RevokeOtherKeys deletes all keys of name except the default key, and returns how many were deleted.
+func (db *Database) RevokeOtherKeys(name string) (int64, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("delete from `user_keys` where `name` = ? and `label` != ?", name, DefaultKeyLabel)
+	if err != nil {
+		return 0, fmt.Errorf("db: deleting keys of '%s' other than the default key: %v", name, err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// GetKeys returns all keys name can currently authenticate with. If there are none, the error is a UserNotFoundError.
+func (db *Database) GetKeys(name string) ([]Key, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	keys := []Key{}
+	err := db.Select(&keys, "select * from `user_keys` where `name` = ? and "+active+" order by `label`, `created_at`", name)
+	if err != nil {
+		return nil, fmt.Errorf("db: retrieving keys of '%s': %v", name, err)
+	}
+	if len(keys) == 0 {
+		return nil, UserNotFoundError(name)
+	}
+	return keys, nil
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// confusables maps characters (after lowercasing) and character sequences to the character they are easily mistaken
+// for in Sauerbraten's font.
+var confusables = strings.NewReplacer(
+	"0", "o",
+	"1", "l",
+	"i", "l",
+	"|", "l",
+	"!", "l",
+	"5", "s",
+	"rn", "m",
+	"vv", "w",
+)
+
+// skeleton returns a normalized form of name that is the same for names that only differ in case or in characters that
+// look alike. Two names with the same skeleton can't both be registered.
+func skeleton(name string) string {
+	return confusables.Replace(strings.ToLower(name))
+}
+
+// fillNameSkeletons computes the skeletons of names registered before skeletons were stored.
+func (db *Database) fillNameSkeletons() error {
+	names := []string{}
+	err := db.Select(&names, "select `name` from `users` where `name_skeleton` is null")
+	if err != nil {
+		return fmt.Errorf("db: retrieving users without name skeleton: %v", err)
+	}
+	for _, name := range names {
+		_, err := db.Exec("update `users` set `name_skeleton` = ? where `name` = ?", skeleton(name), name)
+		if err != nil {
+			return fmt.Errorf("db: storing name skeleton of '%s': %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddUserServer records that name authenticated on the game server at addr, and reports whether that never happened
+// before. New servers are reported to the account's owner by the next notification claimed with ClaimNotification.
+func (db *Database) AddUserServer(name, addr string) (bool, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("insert or ignore into `user_servers` (`name`, `server_addr`, `notified`) values (?, ?, 0)", name, addr)
+	if err != nil {
+		return false, fmt.Errorf("db: inserting ('%s', '%s') into user_servers table: %v", name, addr, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("db: inserting ('%s', '%s') into user_servers table: %v", name, addr, err)
+	}
+	return n > 0, nil
+}
+
+// ClaimNotification returns the game servers the owner of the account name should be notified about: the servers added
+// since the last notification. It returns no servers if the owner was notified less than minInterval ago; those servers
+// are returned by a later call instead. If the owner opted out of notifications or has no Discord ID yet, the servers
+// are dropped. Returned servers count as notified, and the notification as sent.
+func (db *Database) ClaimNotification(name string, minInterval time.Duration) (discordID string, servers []string, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", nil, fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	user := struct {
+		DiscordID      sql.NullString `json:"discord_id"`
+		Notify         bool           `json:"notify_new_servers"`
+		LastNotifiedAt int64          `json:"last_notified_at"`
+	}{}
+	err = tx.Get(&user, "select `discord_id`, `notify_new_servers`, `last_notified_at` from `users` where `name` = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, UserNotFoundError(name)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("db: retrieving notification settings of '%s': %w", name, err)
+	}
+
+	if !user.DiscordID.Valid || !user.Notify {
+		_, err = tx.Exec("update `user_servers` set `notified` = 1 where `name` = ? and `notified` = 0", name)
+		if err != nil {
+			return "", nil, fmt.Errorf("db: dropping notifications of '%s': %w", name, err)
+		}
+		return "", nil, tx.Commit()
+	}
+	if time.Since(time.Unix(user.LastNotifiedAt, 0)) < minInterval {
+		return "", nil, nil
+	}
+
+	err = tx.Select(&servers, "select `server_addr` from `user_servers` where `name` = ? and `notified` = 0 order by `first_seen_at`, `server_addr`", name)
+	if err != nil {
+		return "", nil, fmt.Errorf("db: retrieving new servers of '%s': %w", name, err)
+	}
+	if len(servers) == 0 {
+		return "", nil, nil
+	}
+
+	_, err = tx.Exec("update `user_servers` set `notified` = 1 where `name` = ? and `notified` = 0", name)
+	if err != nil {
+		return "", nil, fmt.Errorf("db: updating 'notified' field of servers of '%s': %w", name, err)
+	}
+	_, err = tx.Exec("update `users` set `last_notified_at` = strftime('%s', 'now') where `name` = ?", name)
+	if err != nil {
+		return "", nil, fmt.Errorf("db: updating 'last_notified_at' field of user '%s': %w", name, err)
+	}
+
+	return user.DiscordID.String, servers, tx.Commit()
+}
+
+// UnclaimNotification undoes ClaimNotification after the notification about servers could not be sent, so they are
+// included in the next one.
+func (db *Database) UnclaimNotification(name string, servers []string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, addr := range servers {
+		_, err = tx.Exec("update `user_servers` set `notified` = 0 where `name` = ? and `server_addr` = ?", name, addr)
+		if err != nil {
+			return fmt.Errorf("db: resetting 'notified' field of ('%s', '%s'): %w", name, addr, err)
+		}
+	}
+	_, err = tx.Exec("update `users` set `last_notified_at` = 0 where `name` = ?", name)
+	if err != nil {
+		return fmt.Errorf("db: resetting 'last_notified_at' field of user '%s': %w", name, err)
+	}
+	return tx.Commit()
+}
+
+// SetNotifyNewServers sets whether the Discord user with the given ID wants to be notified when their account is used on
+// a new game server.
+func (db *Database) SetNotifyNewServers(discordID string, notify bool) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res, err := db.Exec("update `users` set `notify_new_servers` = ? where `discord_id` = ?", notify, discordID)
+	if err != nil {
+		return fmt.Errorf("db: updating 'notify_new_servers' field of user with Discord ID '%s': %v", discordID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return UserNotFoundError(discordID)
+	}
+	return nil
+}
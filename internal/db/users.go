@@ -2,10 +2,14 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type User struct {
+	DiscordID string `json:"discord_id"`
 	Name      string `json:"name"`
 	PublicKey string `json:"public_key"`
 }
@@ -16,53 +20,165 @@ func (e UserExistsError) Error() string {
 	return fmt.Sprintf("db: user %s already exists (with public key: %s)", e.Name, e.PublicKey)
 }
 
-func (db *Database) AddUser(name string, pubkey string, override bool) error {
+type NameTakenError string
+
+func (e NameTakenError) Error() string {
+	return fmt.Sprintf("db: name %s is already taken", string(e))
+}
+
+// AddUser registers the Discord user with the given ID under name, with pubkey as their default key. If the Discord
+// user already has an account, name is ignored and the account keeps its name; if override is set, an existing default
+// key is replaced. The name of the account is returned.
+func (db *Database) AddUser(discordID, name, pubkey string, override bool) (string, error) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	if !override {
-		err := db.Get(&pubkey, "select `pubkey` from `users` where `name` = ?", name)
-		if err == nil {
-			return UserExistsError(User{name, pubkey})
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existingName string
+	err = tx.Get(&existingName, "select `name` from `users` where `discord_id` = ?", discordID)
+	switch {
+	case err == nil:
+		name = existingName
+		if !override {
+			var existing string
+			err := tx.Get(&existing, "select `pubkey` from `user_keys` where `name` = ? and `expires_at` is null order by `label` = 'default' desc, `created_at` asc limit 1", name)
+			if err == nil {
+				return "", UserExistsError(User{discordID, name, existing})
+			}
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		err = checkNameAvailable(tx, name, discordID)
+		if err != nil {
+			return "", err
 		}
+		_, err = tx.Exec("insert into `users` (`name`, `name_skeleton`, `discord_id`) values (?, ?, ?)", name, skeleton(name), discordID)
+		if err != nil {
+			return "", fmt.Errorf("db: inserting ('%s', '%s') into users table: %w", name, discordID, err)
+		}
+	default:
+		return "", fmt.Errorf("db: looking up user with Discord ID '%s': %w", discordID, err)
 	}
 
-	insert := "insert"
-	if override {
-		insert = "insert or replace"
+	_, err = tx.Exec("delete from `user_keys` where `name` = ? and `label` = ?", name, DefaultKeyLabel)
+	if err != nil {
+		return "", fmt.Errorf("db: deleting default key of '%s': %w", name, err)
 	}
 
-	_, err := db.Exec(fmt.Sprintf("%s into `users` (`name`, `pubkey`) values (?, ?)", insert), name, pubkey)
+	_, err = tx.Exec("insert into `user_keys` (`name`, `label`, `pubkey`) values (?, ?, ?)", name, DefaultKeyLabel, pubkey)
 	if err != nil {
-		return fmt.Errorf("db: inserting ('%s', '%s') into users table: %w", name, pubkey, err)
+		return "", fmt.Errorf("db: inserting ('%s', '%s', '%s') into user_keys table: %w", name, DefaultKeyLabel, pubkey, err)
 	}
 
+	return name, tx.Commit()
+}
+
+// checkNameAvailable returns a NameTakenError if an account other than the one of the Discord user with the given ID
+// has a name that looks like name.
+func checkNameAvailable(tx *sqlx.Tx, name, discordID string) error {
+	var existing string
+	err := tx.Get(&existing, "select `name` from `users` where `name_skeleton` = ? and `discord_id` is not ? limit 1", skeleton(name), discordID)
+	if err == nil {
+		return NameTakenError(existing)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("db: checking if '%s' is taken: %w", name, err)
+	}
 	return nil
 }
 
-type UserNotFoundError string
+// RenameUser changes the name of the account belonging to the Discord user with the given ID. Keys are kept.
+func (db *Database) RenameUser(discordID, newName string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
 
-func (e UserNotFoundError) Error() string {
-	return fmt.Sprintf("db: no user named %s", string(e))
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("db: starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = checkNameAvailable(tx, newName, discordID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("update `users` set `name` = ?, `name_skeleton` = ? where `discord_id` = ?", newName, skeleton(newName), discordID)
+	if err != nil {
+		return fmt.Errorf("db: renaming user with Discord ID '%s' to '%s': %w", discordID, newName, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return UserNotFoundError(discordID)
+	}
+
+	return tx.Commit()
 }
 
-func (db *Database) GetPublicKey(name string) (pubkey string, err error) {
+// UserName returns the name of the account belonging to the Discord user with the given ID.
+func (db *Database) UserName(discordID string) (string, error) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	err = db.Get(&pubkey, "select `pubkey` from `users` where `name` = ?", name)
+	var name string
+	err := db.Get(&name, "select `name` from `users` where `discord_id` = ?", discordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UserNotFoundError(discordID)
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", UserNotFoundError(name)
+		return "", fmt.Errorf("db: looking up user with Discord ID '%s': %v", discordID, err)
+	}
+	return name, nil
+}
+
+// ClaimLegacyAccount links an account and ban created before Discord IDs were stored to the Discord user with the given
+// ID. legacyNames are the username#discriminator names the user could have been stored under. Accounts and bans that
+// already belong to a Discord ID are never touched.
+func (db *Database) ClaimLegacyAccount(discordID string, legacyNames ...string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, name := range legacyNames {
+		_, err := db.Exec("update `users` set `discord_id` = ? where `name` = ? and `discord_id` is null and not exists (select 1 from `users` where `discord_id` = ?)", discordID, name, discordID)
+		if err != nil {
+			return fmt.Errorf("db: claiming user '%s' for Discord ID '%s': %v", name, discordID, err)
+		}
+		_, err = db.Exec("update `bans` set `discord_id` = ? where `name` = ? and `discord_id` is null and not exists (select 1 from `bans` where `discord_id` = ?)", discordID, name, discordID)
+		if err != nil {
+			return fmt.Errorf("db: claiming ban of '%s' for Discord ID '%s': %v", name, discordID, err)
 		}
-		err = fmt.Errorf("db: retrieving public key of '%s': %v", name, err)
-		return
 	}
+	return nil
+}
+
+// DiscordID returns the ID of the Discord user the account name belongs to. It is empty for accounts from before
+// Discord IDs were stored.
+func (db *Database) DiscordID(name string) (string, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var discordID sql.NullString
+	err := db.Get(&discordID, "select `discord_id` from `users` where `name` = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UserNotFoundError(name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("db: looking up Discord ID of '%s': %v", name, err)
+	}
+	return discordID.String, nil
+}
 
-	return
+type UserNotFoundError string
+
+func (e UserNotFoundError) Error() string {
+	return fmt.Sprintf("db: no user named %s", string(e))
 }
 
-func (db *Database) UpdateUserLastAuthed(name string) error {
+// UpdateUserLastAuthed records that name just authenticated using the key with ID keyID.
+func (db *Database) UpdateUserLastAuthed(name string, keyID int64) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
@@ -70,16 +186,21 @@ func (db *Database) UpdateUserLastAuthed(name string) error {
 	if err != nil {
 		return fmt.Errorf("db: updating 'last_authed_at' field of user '%s': %v", name, err)
 	}
+	_, err = db.Exec("update `user_keys` set `last_authed_at` = strftime('%s', 'now') where `id` = ?", keyID)
+	if err != nil {
+		return fmt.Errorf("db: updating 'last_authed_at' field of key %d: %v", keyID, err)
+	}
 	return nil
 }
 
-func (db *Database) DelUser(name string) error {
+// DelUser deletes the account of the Discord user with the given ID, including all of its keys.
+func (db *Database) DelUser(discordID string) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	_, err := db.Exec("delete from `users` where `name` = ?", name)
+	_, err := db.Exec("delete from `users` where `discord_id` = ?", discordID)
 	if err != nil {
-		return fmt.Errorf("db: deleting '%s' from users table: %v", name, err)
+		return fmt.Errorf("db: deleting user with Discord ID '%s' from users table: %v", discordID, err)
 	}
 	return nil
 }
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	t.Chdir("../..") // for the migrations directory
+	db, err := New(filepath.Join(t.TempDir(), "users.sqlite"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestClaimLegacyAccount(t *testing.T) {
+	db := newTestDatabase(t)
+
+	// an account and ban from before Discord IDs were stored
+	db.MustExec("insert into `users` (`name`) values ('bob#1234')")
+	db.MustExec("insert into `bans` (`name`) values ('bob#1234')")
+
+	err := db.ClaimLegacyAccount("3", "bob#1234", "bob #1234")
+	if err != nil {
+		t.Fatalf("claiming legacy account: %v", err)
+	}
+	name, err := db.UserName("3")
+	if err != nil || name != "bob#1234" {
+		t.Errorf("expected account bob#1234 to belong to 3, got '%s', %v", name, err)
+	}
+	if banned, err := db.IsBanned("3"); err != nil || !banned {
+		t.Errorf("expected ban of bob#1234 to apply to 3, got %v, %v", banned, err)
+	}
+
+	// claimed accounts can't be claimed again
+	err = db.ClaimLegacyAccount("4", "bob#1234")
+	if err != nil {
+		t.Fatalf("claiming legacy account: %v", err)
+	}
+	if _, err := db.UserName("4"); err == nil {
+		t.Error("account claimed by 3 was claimed by 4, too")
+	}
+
+	// users who registered since keep their new account
+	db.MustExec("insert into `users` (`name`) values ('carol#42')")
+	_, err = db.AddUser("5", "carol", "key", false)
+	if err != nil {
+		t.Fatalf("adding user: %v", err)
+	}
+	err = db.ClaimLegacyAccount("5", "carol#42")
+	if err != nil {
+		t.Fatalf("claiming legacy account: %v", err)
+	}
+	if name, err := db.UserName("5"); err != nil || name != "carol" {
+		t.Errorf("expected 5 to keep account carol, got '%s', %v", name, err)
+	}
+	var owner *string
+	db.Get(&owner, "select `discord_id` from `users` where `name` = 'carol#42'")
+	if owner != nil {
+		t.Errorf("expected legacy account carol#42 to stay unclaimed, got owner %s", *owner)
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	db := newTestDatabase(t)
+
+	for id, name := range map[string]string{"1": "alice", "2": "bob"} {
+		if _, err := db.AddUser(id, name, "key"+id, false); err != nil {
+			t.Fatalf("adding user %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		discordID, newName string
+		taken              string // name the new one conflicts with
+		notFound           bool
+	}{
+		{discordID: "2", newName: "alice", taken: "alice"},
+		{discordID: "2", newName: "AL1CE", taken: "alice"},
+		{discordID: "2", newName: "ALlCE", taken: "alice"},
+		{discordID: "1", newName: "Alice"}, // only the account itself looks like that
+		{discordID: "3", newName: "carol", notFound: true},
+		{discordID: "2", newName: "bobby"},
+		{discordID: "1", newName: "b0bby", taken: "bobby"},
+	}
+	for _, test := range tests {
+		err := db.RenameUser(test.discordID, test.newName)
+		var taken NameTakenError
+		switch {
+		case test.taken != "":
+			if !errors.As(err, &taken) || string(taken) != test.taken {
+				t.Errorf("renaming %s to %s: expected %s to be taken, got %v", test.discordID, test.newName, test.taken, err)
+			}
+		case test.notFound:
+			if !errors.As(err, new(UserNotFoundError)) {
+				t.Errorf("renaming %s to %s: expected user not to be found, got %v", test.discordID, test.newName, err)
+			}
+		case err != nil:
+			t.Errorf("renaming %s to %s: %v", test.discordID, test.newName, err)
+		}
+	}
+
+	if name, err := db.UserName("1"); err != nil || name != "Alice" {
+		t.Errorf("expected 1 to be named Alice, got '%s', %v", name, err)
+	}
+	if name, err := db.UserName("2"); err != nil || name != "bobby" {
+		t.Errorf("expected 2 to be named bobby, got '%s', %v", name, err)
+	}
+	if keys, err := db.GetKeys("bobby"); err != nil || len(keys) != 1 || keys[0].PublicKey != "key2" {
+		t.Errorf("expected bobby to keep key2, got %v, %v", keys, err)
+	}
+}
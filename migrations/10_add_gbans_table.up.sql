@@ -0,0 +1,9 @@
+create table `gbans` (
+	`id` integer primary key autoincrement,
+	`prefix` text not null unique, -- banned IP range in CIDR notation, e.g. '1.2.3.0/24'
+	`reason` text not null default '',
+	`issuer_id` text not null default '', -- Discord user ID of the admin
+	`issuer_name` text not null default '',
+	`created_at` integer not null default (strftime('%s', 'now')),
+	`expires_at` integer -- null means the ban is permanent
+);
@@ -0,0 +1 @@
+alter table `user_servers` drop column `notified`;
@@ -0,0 +1 @@
+alter table `user_servers` add column `notified` integer not null default 1; -- 0 while the owner wasn't told about the server yet
@@ -0,0 +1,8 @@
+alter table `users` add column `pubkey` text not null default '';
+update `users` set `pubkey` = coalesce((
+	select `pubkey` from `user_keys`
+	where `user_keys`.`name` = `users`.`name` and `expires_at` is null
+	order by `label` = 'default' desc, `created_at` asc
+	limit 1
+), '');
+drop table if exists `user_keys`;
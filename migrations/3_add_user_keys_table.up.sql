@@ -0,0 +1,13 @@
+create table `user_keys` (
+	`id` integer primary key autoincrement,
+	`name` text not null references `users` (`name`) on update cascade on delete cascade,
+	`label` text not null,
+	`pubkey` text not null,
+	`created_at` integer not null default (strftime('%s', 'now')),
+	`expires_at` integer, -- null means the key does not expire; set when a key is replaced with a grace period
+	`last_authed_at` integer not null default 0
+);
+create unique index `user_keys_label` on `user_keys` (`name`, `label`) where `expires_at` is null;
+insert into `user_keys` (`name`, `label`, `pubkey`, `created_at`, `last_authed_at`)
+	select `name`, 'default', `pubkey`, `created_at`, `last_authed_at` from `users`;
+alter table `users` drop column `pubkey`;
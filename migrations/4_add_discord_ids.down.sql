@@ -0,0 +1,10 @@
+create table `bans_old` (
+	`name` text primary key,
+	`created_at` integer not null default (strftime('%s', 'now'))
+);
+insert or ignore into `bans_old` (`name`, `created_at`) select `name`, `created_at` from `bans`;
+drop table `bans`;
+alter table `bans_old` rename to `bans`;
+
+drop index `users_discord_id`;
+alter table `users` drop column `discord_id`;
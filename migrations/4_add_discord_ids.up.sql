@@ -0,0 +1,14 @@
+-- Discord user IDs can't be derived from the stored username#discriminator names, so existing rows start out without
+-- one and are claimed the first time their owner talks to the bot (see Database.ClaimLegacyAccount).
+alter table `users` add column `discord_id` text;
+create unique index `users_discord_id` on `users` (`discord_id`);
+
+create table `bans_new` (
+	`id` integer primary key autoincrement,
+	`discord_id` text unique,
+	`name` text not null, -- Discord handle at the time of the ban, for display and for claiming legacy bans
+	`created_at` integer not null default (strftime('%s', 'now'))
+);
+insert into `bans_new` (`name`, `created_at`) select `name`, `created_at` from `bans`;
+drop table `bans`;
+alter table `bans_new` rename to `bans`;
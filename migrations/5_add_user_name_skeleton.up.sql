@@ -0,0 +1,3 @@
+-- filled in by the application, see skeleton() in internal/db/names.go
+alter table `users` add column `name_skeleton` text;
+create index `users_name_skeleton` on `users` (`name_skeleton`);
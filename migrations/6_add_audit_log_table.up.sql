@@ -0,0 +1,8 @@
+create table `audit_log` (
+	`id` integer primary key autoincrement,
+	`actor_id` text not null, -- Discord user ID
+	`actor_name` text not null,
+	`command` text not null,
+	`args` text not null,
+	`created_at` integer not null default (strftime('%s', 'now'))
+);
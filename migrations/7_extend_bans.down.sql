@@ -0,0 +1,6 @@
+delete from `bans` where `suspension`;
+alter table `bans` drop column `suspension`;
+alter table `bans` drop column `expires_at`;
+alter table `bans` drop column `issuer_name`;
+alter table `bans` drop column `issuer_id`;
+alter table `bans` drop column `reason`;
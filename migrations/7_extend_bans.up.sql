@@ -0,0 +1,5 @@
+alter table `bans` add column `reason` text not null default '';
+alter table `bans` add column `issuer_id` text not null default ''; -- Discord user ID of the moderator or admin
+alter table `bans` add column `issuer_name` text not null default '';
+alter table `bans` add column `expires_at` integer; -- null means the ban is permanent
+alter table `bans` add column `suspension` integer not null default 0; -- if set, the account is kept but can't be used
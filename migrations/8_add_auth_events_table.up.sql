@@ -0,0 +1,12 @@
+create table `auth_events` (
+	`id` integer primary key autoincrement,
+	`name` text not null, -- empty if the request ID was unknown
+	`server_addr` text not null,
+	`request_id` integer not null,
+	`outcome` text not null,
+	`requested_at` integer not null, -- when the game server asked for a challenge
+	`created_at` integer not null -- when the outcome was determined
+);
+create index `auth_events_name` on `auth_events` (`name`, `created_at`);
+create index `auth_events_server_addr` on `auth_events` (`server_addr`, `created_at`);
+create index `auth_events_created_at` on `auth_events` (`created_at`);
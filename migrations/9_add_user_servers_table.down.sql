@@ -0,0 +1,3 @@
+alter table `users` drop column `last_notified_at`;
+alter table `users` drop column `notify_new_servers`;
+drop table if exists `user_servers`;
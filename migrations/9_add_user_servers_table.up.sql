@@ -0,0 +1,8 @@
+create table `user_servers` (
+	`name` text not null references `users` (`name`) on update cascade on delete cascade,
+	`server_addr` text not null, -- IP address of the game server
+	`first_seen_at` integer not null default (strftime('%s', 'now')),
+	primary key (`name`, `server_addr`)
+);
+alter table `users` add column `notify_new_servers` integer not null default 1;
+alter table `users` add column `last_notified_at` integer not null default 0;
@@ -1,36 +1,111 @@
 package auth
 
 import (
-	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"strings"
+	"sync"
 )
 
+// Errors wrapped in a *KeyError by ParsePublicKey and ParsePrivateKey, describing why a string is not a usable key.
+var (
+	ErrKeyEmpty      = errors.New("key is empty")
+	ErrKeyZero       = errors.New("key is zero")
+	ErrKeyPrefix     = errors.New("key does not start with '+' or '-'")
+	ErrKeyIsPrivate  = errors.New("key is a private key, not a public key")
+	ErrKeyNotHex     = errors.New("key is not a hexadecimal number")
+	ErrKeyOutOfRange = errors.New("key is too large")
+	ErrKeyNotOnCurve = errors.New("key is not a point on the curve")
+	ErrKeyInfinity   = errors.New("key is the point at infinity")
+	ErrKeyWeak       = errors.New("key belongs to a trivially guessable private key")
+)
+
+// KeyError is returned when parsing a key fails.
+type KeyError struct {
+	Key string // empty for private keys, so they don't end up in logs
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("auth: invalid key: %v", e.Err)
+	}
+	return fmt.Sprintf("auth: invalid key '%s': %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
 type PrivateKey []byte
 
+// ParsePrivateKey parses a private key in the format printed by Sauerbraten's genauthkey command. It fails with a
+// *KeyError if s is not a hexadecimal number in the range [1, N-1].
 func ParsePrivateKey(s string) (PrivateKey, error) {
-	return hex.DecodeString(s)
+	if len(s) == 0 {
+		return nil, &KeyError{Err: ErrKeyEmpty}
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &KeyError{Err: ErrKeyNotHex}
+	}
+	k := PrivateKey(b)
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
 }
 
 func (k PrivateKey) String() string {
 	return hex.EncodeToString(k)
 }
 
+// Validate checks that k is a valid private key for P-192, i.e. in the range [1, N-1].
+func (k PrivateKey) Validate() error {
+	n := new(big.Int).SetBytes(k)
+	if n.Sign() == 0 {
+		return &KeyError{Err: ErrKeyZero}
+	}
+	if n.Cmp(p192.N) >= 0 {
+		return &KeyError{Err: ErrKeyOutOfRange}
+	}
+	return nil
+}
+
+// Public computes the public key belonging to k, like Sauerbraten's getpubkey command.
+func (k PrivateKey) Public() (PublicKey, error) {
+	if err := k.Validate(); err != nil {
+		return PublicKey{}, err
+	}
+	x, y := scalarBaseMult(k).affine()
+	return PublicKey{x: x, y: y}, nil
+}
+
 type PublicKey struct {
 	x *big.Int
 	y *big.Int
 }
 
+// ParsePublicKey parses a public key in the format printed by Sauerbraten's getpubkey command. It fails with a *KeyError
+// if s is not a valid point on the curve or belongs to a trivially weak private key.
 func ParsePublicKey(s string) (PublicKey, error) {
 	x, y, err := parsePoint(s)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	if isWeak(x) {
+		return PublicKey{}, &KeyError{s, ErrKeyWeak}
+	}
 	return PublicKey{
 		x: x,
 		y: y,
-	}, err
+	}, nil
 }
 
 func (k PublicKey) String() string {
@@ -52,35 +127,90 @@ func (k *PublicKey) UnmarshalJSON(data []byte) error {
 	return err
 }
 
-var p192 *elliptic.CurveParams
+func GenerateKeyPair() (priv PrivateKey, pub PublicKey, err error) {
+	priv, err = randomScalar()
+	if err != nil {
+		return nil, PublicKey{}, fmt.Errorf("auth: generating private key: %w", err)
+	}
+	pub.x, pub.y = scalarBaseMult(priv).affine()
+	return
+}
 
-func init() {
-	p192 = &elliptic.CurveParams{Name: "P-192", BitSize: 192}
-	p192.P, _ = new(big.Int).SetString("6277101735386680763835789423207666416083908700390324961279", 10)
-	p192.N, _ = new(big.Int).SetString("6277101735386680763835789423176059013767194773182842284081", 10)
-	p192.B, _ = new(big.Int).SetString("64210519e59c80e70fa7e9ab72243049feb8deecc146b9b1", 16)
-	p192.Gx, _ = new(big.Int).SetString("188da80eb03090f67cbf20eb43a18800f4ff0afd82ff1012", 16)
-	p192.Gy, _ = new(big.Int).SetString("07192b95ffc8da78631011ed6b24cdd573f977a11e794811", 16)
+// randomScalar returns a random number in [1, N-1].
+func randomScalar() ([]byte, error) {
+	k := make([]byte, p192.BitSize/8)
+	for {
+		_, err := rand.Read(k)
+		if err != nil {
+			return nil, err
+		}
+		n := new(big.Int).SetBytes(k)
+		if n.Sign() != 0 && n.Cmp(p192.N) < 0 {
+			return k, nil
+		}
+	}
 }
 
-func GenerateKeyPair() (priv PrivateKey, pub PublicKey, err error) {
-	priv, pub.x, pub.y, err = elliptic.GenerateKey(p192, rand.Reader)
+// GenerateKeyPairFromSeed derives a key pair from a secret string, exactly like Sauerbraten's genauthkey command (see
+// genprivkey() in shared/crypto.cpp): the private key is the Tiger hash of the seed.
+func GenerateKeyPairFromSeed(seed string) (priv PrivateKey, pub PublicKey, err error) {
+	// C strings end at the first NUL byte
+	if i := strings.IndexByte(seed, 0); i >= 0 {
+		seed = seed[:i]
+	}
+	if seed == "" {
+		return nil, PublicKey{}, errors.New("auth: seed must not be empty")
+	}
+
+	priv = privateKeyFromHash(tiger([]byte(seed)))
+	pub, err = priv.Public()
 	return
 }
 
+// privateKeyFromHash interprets hash as a little-endian number (genprivkey() copies the hash bytes into the digits of
+// a bigint) and drops leading zero digits like bigint::shrink(), so that String() matches bigint::printdigits().
+func privateKeyFromHash(hash [tigerSize]byte) PrivateKey {
+	priv := make(PrivateKey, tigerSize)
+	for i, b := range hash {
+		priv[tigerSize-1-i] = b
+	}
+	// a bigint digit has 16 bits
+	for len(priv) >= 2 && priv[0] == 0 && priv[1] == 0 {
+		priv = priv[2:]
+	}
+	return priv
+}
+
 func GenerateChallenge(pub PublicKey) (challenge, solution string, err error) {
-	secret, x, y, err := elliptic.GenerateKey(p192, rand.Reader)
+	challenge, solutions, err := GenerateChallengeForKeys(pub)
 	if err != nil {
-		return "", "", fmt.Errorf("generating challenge: %w", err)
+		return "", "", err
+	}
+	return challenge, solutions[0], nil
+}
+
+// GenerateChallengeForKeys returns a single challenge that can be answered using the private key belonging to any of
+// pubs. solutions[i] is the answer expected from the owner of pubs[i].
+func GenerateChallengeForKeys(pubs ...PublicKey) (challenge string, solutions []string, err error) {
+	if len(pubs) == 0 {
+		return "", nil, errors.New("generating challenge: no public keys")
+	}
+
+	secret, err := randomScalar()
+	if err != nil {
+		return "", nil, fmt.Errorf("generating challenge: %w", err)
 	}
 
 	// what we send to the client
-	challenge = encodePoint(x, y)
+	challenge = encodePoint(scalarBaseMult(secret).affine())
 
 	// what the client should return if she applies her private key to the challenge
 	// (see Solve below)
-	solX, _ := p192.ScalarMult(pub.x, pub.y, secret)
-	solution = solX.Text(16)
+	solutions = make([]string, len(pubs))
+	for i, pub := range pubs {
+		solX, _ := scalarMult(newPointFromAffine(pub.x, pub.y), secret).affine()
+		solutions[i] = printDigits(solX)
+	}
 
 	return
 }
@@ -91,8 +221,41 @@ func Solve(challenge string, priv PrivateKey) (string, error) {
 		return "", err
 	}
 
-	solX, _ := p192.ScalarMult(x, y, priv)
-	return solX.Text(16), nil
+	solX, _ := scalarMult(newPointFromAffine(x, y), priv).affine()
+	return printDigits(solX), nil
+}
+
+var (
+	ErrMalformedAnswer = errors.New("auth: malformed answer")
+	ErrWrongAnswer     = errors.New("auth: wrong answer")
+)
+
+// VerifyAnswer checks a client's answer to a challenge against the solution returned by GenerateChallenge. Answers
+// are compared as numbers, so leading zeros and upper-case digits are fine, and in constant time. An answer that is
+// not a hexadecimal number of at most 192 bits results in ErrMalformedAnswer.
+func VerifyAnswer(answer, solution string) (bool, error) {
+	a, ok := decodeAnswer(answer)
+	if !ok {
+		return false, ErrMalformedAnswer
+	}
+	s, ok := decodeAnswer(solution)
+	if !ok {
+		return false, fmt.Errorf("auth: malformed solution '%s'", solution)
+	}
+	return subtle.ConstantTimeCompare(a[:], s[:]) == 1, nil
+}
+
+// decodeAnswer decodes a hexadecimal number of at most 192 bits into a fixed-size big-endian byte array.
+func decodeAnswer(s string) (b [24]byte, ok bool) {
+	digits := strings.TrimLeft(s, "0")
+	if len(s) == 0 || len(digits) > 2*len(b) {
+		return b, false
+	}
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	n, err := hex.Decode(b[len(b)-len(digits)/2:], []byte(digits))
+	return b, err == nil && n == len(digits)/2
 }
 
 // from ecjacobian::print() in shared/crypto.cpp
@@ -102,19 +265,46 @@ func encodePoint(x, y *big.Int) (s string) {
 	} else {
 		s += "+"
 	}
-	s += x.Text(16)
+	s += printDigits(x)
 	return
 }
 
+// printDigits encodes x like bigint::printdigits() in shared/crypto.cpp: in hex, in groups of four digits.
+func printDigits(x *big.Int) string {
+	s := x.Text(16)
+	if r := len(s) % 4; r != 0 {
+		s = strings.Repeat("0", 4-r) + s
+	}
+	return s
+}
+
+// parsePoint decodes a curve point in the format written by encodePoint.
 func parsePoint(s string) (x, y *big.Int, err error) {
 	if len(s) < 1 {
-		return nil, nil, errors.New("auth: could not parse curve point: too short")
+		return nil, nil, &KeyError{s, ErrKeyEmpty}
+	}
+	if s[0] != '+' && s[0] != '-' {
+		if _, err := ParsePrivateKey(s); err == nil {
+			return nil, nil, &KeyError{Err: ErrKeyIsPrivate}
+		}
+		return nil, nil, &KeyError{s, ErrKeyPrefix}
 	}
 
-	var ok bool
-	x, ok = new(big.Int).SetString(s[1:], 16)
-	if !ok {
-		return nil, nil, errors.New("auth: could not set X coordinate of curve point")
+	digits := s[1:]
+	if len(digits) == 0 || strings.TrimLeft(digits, "0123456789abcdefABCDEF") != "" {
+		return nil, nil, &KeyError{s, ErrKeyNotHex}
+	}
+	if len(digits) > 2*p192.BitSize/8 {
+		return nil, nil, &KeyError{s, ErrKeyOutOfRange}
+	}
+
+	x, _ = new(big.Int).SetString(digits, 16)
+	if x.Cmp(p192.P) >= 0 {
+		return nil, nil, &KeyError{s, ErrKeyOutOfRange}
+	}
+	if x.Sign() == 0 {
+		// that's how the point at infinity comes out of ecjacobian::print()
+		return nil, nil, &KeyError{s, ErrKeyInfinity}
 	}
 
 	// the next steps find y using the formula y^2 = x^3 - 3x + B
@@ -127,13 +317,45 @@ func parsePoint(s string) (x, y *big.Int, err error) {
 	// x^3 - 3x + B
 	yy := new(big.Int).Sub(xxx, threeX)
 	yy.Add(yy, p192.B)
+	yy.Mod(yy, p192.P)
 
-	// find a square root
+	// find a square root; if there is none, no point on the curve has this X coordinate
 	y = new(big.Int).ModSqrt(yy, p192.P)
+	if y == nil {
+		return nil, nil, &KeyError{s, ErrKeyNotOnCurve}
+	}
 
-	if s[0] == '-' && y.Bit(0) == 0 {
+	// '-' means odd Y, '+' means even Y
+	if odd := s[0] == '-'; (y.Bit(0) == 1) != odd {
 		y.Sub(p192.P, y)
 	}
 
+	// a point with Y = 0 would be its own inverse, which would put the point at infinity into our prime order group
+	if y.Sign() == 0 {
+		return nil, nil, &KeyError{s, ErrKeyNotOnCurve}
+	}
+
 	return
 }
+
+var (
+	weakKeysOnce sync.Once
+	weakKeys     map[string]struct{} // X coordinates of k*G for small k
+)
+
+// isWeak reports whether x is the X coordinate of k*G or -k*G for a small k, i.e. of a public key whose private key is
+// trivial to guess.
+func isWeak(x *big.Int) bool {
+	weakKeysOnce.Do(func() {
+		weakKeys = map[string]struct{}{}
+		g := newPointFromAffine(p192.Gx, p192.Gy)
+		kg := g
+		for range 256 {
+			kx, _ := kg.affine()
+			weakKeys[string(kx.Bytes())] = struct{}{}
+			kg = pointAdd(kg, g)
+		}
+	})
+	_, weak := weakKeys[string(x.Bytes())]
+	return weak
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -72,6 +73,46 @@ func TestPrivateKey(t *testing.T) {
 	}
 }
 
+func TestPrivateKeyPublic(t *testing.T) {
+	for i, pair := range keys {
+		priv, err := ParsePrivateKey(pair.priv)
+		if err != nil {
+			t.Errorf("parsing private part of key pair %d: %v", i+1, err)
+			continue
+		}
+		pub, err := priv.Public()
+		if err != nil {
+			t.Errorf("deriving public key of key pair %d: %v", i+1, err)
+			continue
+		}
+		if pub.String() != pair.pub {
+			t.Errorf("public key derived from private key %d does not match (expected %v, got %v)", i+1, pair.pub, pub.String())
+		}
+	}
+}
+
+func TestInvalidPrivateKey(t *testing.T) {
+	tests := []struct {
+		key string
+		err error
+	}{
+		{"", ErrKeyEmpty},
+		{"00", ErrKeyZero},
+		{"000000000000000000000000000000000000000000000000", ErrKeyZero},
+		{"+2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", ErrKeyNotHex},
+		{"f373de2d49584e7a16166e76b1bb925f24f0130c63ac933g", ErrKeyNotHex},
+		{"ffffffffffffffffffffffff99def836146bc9b1b4d22831", ErrKeyOutOfRange}, // N
+		{"1f373de2d49584e7a16166e76b1bb925f24f0130c63ac9332", ErrKeyOutOfRange},
+	}
+
+	for _, test := range tests {
+		_, err := ParsePrivateKey(test.key)
+		if !errors.Is(err, test.err) {
+			t.Errorf("parsing '%s': expected %v, got %v", test.key, test.err, err)
+		}
+	}
+}
+
 func TestPublicKey(t *testing.T) {
 	for i, pair := range keys {
 		pub, err := ParsePublicKey(pair.pub)
@@ -104,3 +145,197 @@ func TestGenerateKeyPair(t *testing.T) {
 		t.Errorf("challenge answer does not match solution (expected %v, got %v)", solution, answer)
 	}
 }
+
+func TestGenerateChallengeForKeys(t *testing.T) {
+	privs := make([]PrivateKey, 3)
+	pubs := make([]PublicKey, 3)
+	for i := range privs {
+		var err error
+		privs[i], pubs[i], err = GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("generating key pair: %v", err)
+		}
+	}
+
+	challenge, solutions, err := GenerateChallengeForKeys(pubs...)
+	if err != nil {
+		t.Fatalf("generating challenge: %v", err)
+	}
+	if len(solutions) != len(pubs) {
+		t.Fatalf("expected %d solutions, got %d", len(pubs), len(solutions))
+	}
+
+	for i, priv := range privs {
+		answer, err := Solve(challenge, priv)
+		if err != nil {
+			t.Fatalf("solving challenge: %v", err)
+		}
+		for j, solution := range solutions {
+			if (answer == solution) != (i == j) {
+				t.Errorf("answer using key %d: matching solution %d is %v", i, j, answer == solution)
+			}
+		}
+	}
+
+	if _, _, err := GenerateChallengeForKeys(); err == nil {
+		t.Error("expected error generating challenge without keys")
+	}
+}
+
+func TestInvalidPublicKey(t *testing.T) {
+	tests := []struct {
+		key string
+		err error
+	}{
+		{"", ErrKeyEmpty},
+		{"f373de2d49584e7a16166e76b1bb925f24f0130c63ac9332", ErrKeyIsPrivate},
+		{"f373de2d49584e7a16166e76b1bb925f24f0130c63ac933x", ErrKeyPrefix},
+		{"+", ErrKeyNotHex},
+		{"+-2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", ErrKeyNotHex},
+		{"+0x2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", ErrKeyNotHex},
+		{"+2c1fb1dd4f2a7b9d 81320497c64983e92cda412ed50f33aa", ErrKeyNotHex},
+		{"+ffffffffffffffffffffffffffffffffffffffffffffffff", ErrKeyOutOfRange},
+		{"+fffffffffffffffffffffffffffffffeffffffffffffffff", ErrKeyOutOfRange}, // P
+		{"+12c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", ErrKeyOutOfRange},
+		{"+0", ErrKeyInfinity},
+		{"+1", ErrKeyNotOnCurve},
+		{"+188da80eb03090f67cbf20eb43a18800f4ff0afd82ff1012", ErrKeyWeak}, // base point
+		{"-188da80eb03090f67cbf20eb43a18800f4ff0afd82ff1012", ErrKeyWeak},
+		{"+dafebf5828783f2ad35534631588a3f629a70fb16982a888", ErrKeyWeak}, // 2 * base point
+	}
+
+	for _, test := range tests {
+		_, err := ParsePublicKey(test.key)
+		if !errors.Is(err, test.err) {
+			t.Errorf("parsing '%s': expected %v, got %v", test.key, test.err, err)
+		}
+		var keyErr *KeyError
+		if err != nil && !errors.As(err, &keyErr) {
+			t.Errorf("parsing '%s': expected *KeyError, got %T", test.key, err)
+		}
+	}
+}
+
+func FuzzParsePublicKey(f *testing.F) {
+	for _, pair := range keys {
+		f.Add(pair.pub)
+	}
+	f.Add("+0")
+	f.Add("-")
+	f.Fuzz(func(t *testing.T, s string) {
+		pub, err := ParsePublicKey(s)
+		if err != nil {
+			return
+		}
+		again, err := ParsePublicKey(pub.String())
+		if err != nil {
+			t.Fatalf("re-parsing '%s' (from '%s'): %v", pub, s, err)
+		}
+		if again.x.Cmp(pub.x) != 0 || again.y.Cmp(pub.y) != 0 {
+			t.Fatalf("'%s' does not round-trip", s)
+		}
+	})
+}
+
+func FuzzParsePrivateKey(f *testing.F) {
+	for _, pair := range keys {
+		f.Add(pair.priv)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		priv, err := ParsePrivateKey(s)
+		if err != nil {
+			return
+		}
+		if _, err := ParsePrivateKey(priv.String()); err != nil {
+			t.Fatalf("re-parsing '%s' (from '%s'): %v", priv, s, err)
+		}
+	})
+}
+
+func FuzzSolve(f *testing.F) {
+	for _, pair := range keys {
+		priv, _ := ParsePrivateKey(pair.priv)
+		f.Add(pair.pub, []byte(priv))
+	}
+	f.Fuzz(func(t *testing.T, challenge string, priv []byte) {
+		Solve(challenge, priv)
+	})
+}
+
+// key pairs derived from seeds, in the format printed by genauthkey and getpubkey
+var seededKeys = []struct {
+	seed, priv, pub string
+}{
+	{"hello", "f27bdbca396602548a3874f89b1b74f2a78862336f7ffd2c", "+85270e15c38f398b68fd1a94a995a1fb10207f5e7681c6ed"},
+	{"0123456789abcdef0123456789abcdef", "21960f6fdea852bcf102b4f9e18a55c7adf8627a50885438", "+2d78471521332e076634cc7fcda367747b79e7bb8e6e1f2d"},
+	{"p1x.pw rocks", "4f603e6f9074f9defce8dc66465d5f84ee30057a7423903f", "-0e1a5ba2b3111e8ff50928c12e4e9f80750cf8e324a78a94"},
+	{"secret", "5b0e3164a4b52376eb78db47421b82bb0452d6c63aec47fe", "-3f19b1d6311dc7263b0e7650d966328905569364b3745df7"},
+}
+
+func TestGenerateKeyPairFromSeed(t *testing.T) {
+	for _, k := range seededKeys {
+		priv, pub, err := GenerateKeyPairFromSeed(k.seed)
+		if err != nil {
+			t.Errorf("generating key pair from '%s': %v", k.seed, err)
+			continue
+		}
+		if priv.String() != k.priv {
+			t.Errorf("private key derived from '%s' does not match (expected %s, got %s)", k.seed, k.priv, priv)
+		}
+		if pub.String() != k.pub {
+			t.Errorf("public key derived from '%s' does not match (expected %s, got %s)", k.seed, k.pub, pub)
+		}
+	}
+
+	if _, _, err := GenerateKeyPairFromSeed(""); err == nil {
+		t.Error("expected empty seed to be rejected")
+	}
+}
+
+func TestPrivateKeyFromHash(t *testing.T) {
+	var hash [tigerSize]byte
+	for i := range hash {
+		hash[i] = byte(i + 1)
+	}
+
+	// genprivkey() reads the hash as little-endian number
+	if priv := privateKeyFromHash(hash).String(); priv != "1817161514131211100f0e0d0c0b0a090807060504030201" {
+		t.Errorf("expected reversed hash, got %s", priv)
+	}
+
+	// leading zero digits are dropped in groups of four hex digits
+	hash[23], hash[22], hash[21] = 0, 0, 0
+	if priv := privateKeyFromHash(hash).String(); priv != "001514131211100f0e0d0c0b0a090807060504030201" {
+		t.Errorf("expected leading zero digits to be dropped, got %s", priv)
+	}
+}
+
+func TestVerifyAnswer(t *testing.T) {
+	tests := []struct {
+		answer, solution string
+		ok               bool
+		err              error
+	}{
+		{"2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", "2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", true, nil},
+		{"2C1FB1DD4F2A7B9D81320497C64983E92CDA412ED50F33AA", "2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", true, nil},
+		{"0e1a5ba2b3111e8ff50928c12e4e9f80750cf8e324a78a94", "e1a5ba2b3111e8ff50928c12e4e9f80750cf8e324a78a94", true, nil},
+		{"e1a5ba2b3111e8ff50928c12e4e9f80750cf8e324a78a94", "0e1a5ba2b3111e8ff50928c12e4e9f80750cf8e324a78a94", true, nil},
+		{"00000abc", "abc", true, nil},
+		{"2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33ab", "2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", false, nil},
+		{"2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa0", "2c1fb1dd4f2a7b9d81320497c64983e92cda412ed50f33aa", false, ErrMalformedAnswer},
+		{"", "abc", false, ErrMalformedAnswer},
+		{"+abc", "abc", false, ErrMalformedAnswer},
+		{"0xabc", "abc", false, ErrMalformedAnswer},
+		{"abcg", "abc", false, ErrMalformedAnswer},
+	}
+
+	for _, test := range tests {
+		ok, err := VerifyAnswer(test.answer, test.solution)
+		if !errors.Is(err, test.err) {
+			t.Errorf("verifying '%s' against '%s': expected error %v, got %v", test.answer, test.solution, test.err, err)
+		}
+		if ok != test.ok {
+			t.Errorf("verifying '%s' against '%s': expected %v, got %v", test.answer, test.solution, test.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+)
+
+// ContextProvider is the synchronous counterpart of Provider. Cancelling the context passed to Challenge or Confirm
+// aborts the pending request: the provider forgets about it and a late answer will not be accepted.
+type ContextProvider interface {
+	Challenge(ctx context.Context, name string) (reqID uint32, chal string, err error)
+	Confirm(ctx context.Context, reqID uint32, answ string) (Role, error)
+}
+
+var (
+	_ ContextProvider = (*InMemoryProvider)(nil)
+	_ ContextProvider = (*RemoteProvider)(nil)
+)
+
+type challengeResult struct {
+	reqID uint32
+	chal  string
+	err   error
+}
+
+type confirmResult struct {
+	rol Role
+	err error
+}
+
+// challenge uses p's context-aware API if it has one, and waits for p's callback otherwise.
+func challenge(ctx context.Context, p Provider, name string) (uint32, string, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.Challenge(ctx, name)
+	}
+
+	results := make(chan challengeResult, 1) // buffered so a late callback never blocks
+	p.GenerateChallenge(name, func(reqID uint32, chal string, err error) {
+		results <- challengeResult{reqID, chal, err}
+	})
+
+	select {
+	case r := <-results:
+		return r.reqID, r.chal, r.err
+	case <-ctx.Done():
+		return 0, "", ctx.Err()
+	}
+}
+
+// confirm uses p's context-aware API if it has one, and waits for p's callback otherwise.
+func confirm(ctx context.Context, p Provider, reqID uint32, answ string) (Role, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.Confirm(ctx, reqID, answ)
+	}
+
+	results := make(chan confirmResult, 1) // buffered so a late callback never blocks
+	p.ConfirmAnswer(reqID, answ, func(rol Role, err error) {
+		results <- confirmResult{rol, err}
+	})
+
+	select {
+	case r := <-results:
+		return r.rol, r.err
+	case <-ctx.Done():
+		return RoleNone, ctx.Err()
+	}
+}
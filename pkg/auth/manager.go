@@ -1,33 +1,103 @@
 package auth
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/pkg/protocol"
 )
 
+// Provider generates challenges for users and checks the answers to them. On success, ConfirmAnswer reports the role
+// the user authenticated as.
 type Provider interface {
 	GenerateChallenge(name string, callback func(reqID uint32, chal string, err error))
-	ConfirmAnswer(reqID uint32, answ string, callback func(ok bool, err error))
+	ConfirmAnswer(reqID uint32, answ string, callback func(rol Role, err error))
 }
 
+var (
+	_ Provider = (*InMemoryProvider)(nil)
+	_ Provider = (*RemoteProvider)(nil)
+)
+
 type callbacks struct {
 	onSuccess func(Role)
 	onFailure func(error)
 }
 
+// managedRequest links a Manager's request ID to the request in the provider responsible for the domain.
+type managedRequest struct {
+	domain    string
+	reqID     uint32 // ID assigned by the provider
+	createdAt time.Time
+	callbacks
+}
+
+// DefaultRequestLifetime is how long a Manager waits for the answer to a challenge. It is longer than providers keep
+// their requests, so answers are rejected by the provider first.
+const DefaultRequestLifetime = 2 * time.Minute
+
+// Manager is safe for concurrent use, as long as its providers are. Call Close when it is no longer needed.
+//
+// Each provider allocates request IDs on its own, so the Manager hands out its own request IDs to callers and
+// translates them to the provider's ones. This way, providers for different domains can't interfere with each other.
 type Manager struct {
-	providersByDomain  map[string]Provider
-	rolesByDomain      map[string]Role
-	callbacksByRequest map[uint32]callbacks
+	providersByDomain map[string]Provider
+	rolesByDomain     map[string]Role // used when a provider reports RoleNone for a successful authentication
+	stop              chan struct{}
+	closeOnce         sync.Once
+
+	mutex           sync.Mutex // guards the fields below
+	ids             *protocol.IDCycle
+	requests        map[uint32]managedRequest
+	requestLifetime time.Duration
 }
 
 func NewManager(providers map[string]Provider, roles map[string]Role) *Manager {
-	return &Manager{
-		providersByDomain:  providers,
-		rolesByDomain:      roles,
-		callbacksByRequest: map[uint32]callbacks{},
+	return newManager(providers, roles, sweepInterval)
+}
+
+func newManager(providers map[string]Provider, roles map[string]Role, sweepInterval time.Duration) *Manager {
+	m := &Manager{
+		providersByDomain: providers,
+		rolesByDomain:     roles,
+		stop:              make(chan struct{}),
+		ids:               new(protocol.IDCycle),
+		requests:          map[uint32]managedRequest{},
+		requestLifetime:   DefaultRequestLifetime,
+	}
+	go m.run(sweepInterval)
+	return m
+}
+
+func (m *Manager) run(sweepInterval time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.stop:
+			return
+		}
 	}
 }
 
+// Close stops failing requests that are not answered in time. It does not close the providers.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() { close(m.stop) })
+}
+
+// SetRequestLifetime sets how long the Manager waits for the answer to a challenge. Requests that are not answered in
+// time are dropped and fail.
+func (m *Manager) SetRequestLifetime(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requestLifetime = d
+}
+
 func (m *Manager) TryAuthentication(
 	domain, name string,
 	onChal func(reqID uint32, chal string),
@@ -45,36 +115,113 @@ func (m *Manager) TryAuthentication(
 			onFailure(err)
 			return
 		}
-		m.callbacksByRequest[reqID] = callbacks{
-			onSuccess: onSuccess,
-			onFailure: onFailure,
-		}
-		onChal(reqID, chal)
+		id := m.remember(managedRequest{
+			domain: domain,
+			reqID:  reqID,
+			callbacks: callbacks{
+				onSuccess: onSuccess,
+				onFailure: onFailure,
+			},
+		})
+		onChal(id, chal)
 	})
 }
 
-func (m *Manager) CheckAnswer(reqID uint32, domain string, answ string) (err error) {
-	defer delete(m.callbacksByRequest, reqID)
+// CheckAnswer passes the answer to the challenge of request reqID (as passed to onChal in TryAuthentication) to the
+// provider that issued the challenge.
+func (m *Manager) CheckAnswer(reqID uint32, answ string) error {
+	req, ok := m.forget(reqID)
+	if !ok {
+		return fmt.Errorf("auth: unkown request '%d'", reqID)
+	}
+
+	p := m.providersByDomain[req.domain]
+	p.ConfirmAnswer(req.reqID, answ, func(rol Role, err error) {
+		if err != nil {
+			go req.onFailure(err)
+			return
+		}
+		if rol == RoleNone {
+			rol = m.rolesByDomain[req.domain]
+		}
+		go req.onSuccess(rol)
+	})
+
+	return nil
+}
 
+// Challenge is the synchronous counterpart of TryAuthentication. Cancelling ctx aborts the request.
+func (m *Manager) Challenge(ctx context.Context, domain, name string) (reqID uint32, chal string, err error) {
 	p, ok := m.providersByDomain[domain]
 	if !ok {
-		err = fmt.Errorf("auth: no provider for domain '%s'", domain)
-		return
+		return 0, "", fmt.Errorf("auth: no provider for domain '%s'", domain)
 	}
 
-	callbacks, ok := m.callbacksByRequest[reqID]
-	if !ok {
-		err = fmt.Errorf("auth: unkown request '%d'", reqID)
-		return
+	providerReqID, chal, err := challenge(ctx, p, name)
+	if err != nil {
+		return 0, "", err
 	}
 
-	p.ConfirmAnswer(reqID, answ, func(ok bool, err error) {
-		if err != nil {
-			go callbacks.onFailure(err)
-			return
-		}
-		go callbacks.onSuccess(m.rolesByDomain[domain])
+	// requests started here are answered through Confirm, so there is nobody to call back
+	reqID = m.remember(managedRequest{
+		domain: domain,
+		reqID:  providerReqID,
+		callbacks: callbacks{
+			onSuccess: func(Role) {},
+			onFailure: func(error) {},
+		},
 	})
+	return reqID, chal, nil
+}
+
+// Confirm is the synchronous counterpart of CheckAnswer. Cancelling ctx aborts the request.
+func (m *Manager) Confirm(ctx context.Context, reqID uint32, answ string) (Role, error) {
+	req, ok := m.forget(reqID)
+	if !ok {
+		return RoleNone, fmt.Errorf("auth: unkown request '%d'", reqID)
+	}
+
+	rol, err := confirm(ctx, m.providersByDomain[req.domain], req.reqID, answ)
+	if err != nil {
+		return RoleNone, err
+	}
+	if rol == RoleNone {
+		rol = m.rolesByDomain[req.domain]
+	}
+	return rol, nil
+}
+
+// remember stores req and returns the ID callers use to refer to it.
+func (m *Manager) remember(req managedRequest) uint32 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id := m.ids.Next()
+	req.createdAt = time.Now()
+	m.requests[id] = req
+	return id
+}
 
-	return
+// forget removes and returns the request with ID reqID. Expired requests are treated as unknown.
+func (m *Manager) forget(reqID uint32) (managedRequest, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	req, ok := m.requests[reqID]
+	delete(m.requests, reqID)
+	if ok && time.Since(req.createdAt) > m.requestLifetime {
+		return managedRequest{}, false
+	}
+	return req, ok
+}
+
+// sweep drops expired requests and fails them.
+func (m *Manager) sweep() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, req := range m.requests {
+		if time.Since(req.createdAt) > m.requestLifetime {
+			delete(m.requests, id)
+			go req.onFailure(fmt.Errorf("auth: request '%d' expired", id))
+		}
+	}
 }
@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/pkg/protocol"
+)
+
+// fakeMaster answers reqauth and confauth messages sent by a RemoteProvider using a local provider.
+func fakeMaster(t *testing.T, backend *InMemoryProvider) (inc chan string, out chan string) {
+	inc, out = make(chan string), make(chan string)
+	go func() {
+		for msg := range out {
+			var reqID uint32
+			var arg string
+			cmd := strings.Split(msg, " ")[0]
+			_, err := fmt.Sscanf(msg[len(cmd):], "%d %s", &reqID, &arg)
+			if err != nil {
+				t.Errorf("fake master: malformed message '%s': %v", msg, err)
+				continue
+			}
+			switch cmd {
+			case protocol.ReqAuth:
+				backend.GenerateChallenge(arg, func(id uint32, chal string, err error) {
+					if err != nil {
+						inc <- fmt.Sprintf("%s %d", protocol.FailAuth, reqID)
+						return
+					}
+					inc <- fmt.Sprintf("%s %d %s", protocol.ChalAuth, reqID, chal)
+				})
+			case protocol.ConfAuth:
+				backend.ConfirmAnswer(reqID, arg, func(_ Role, err error) {
+					if err != nil {
+						inc <- fmt.Sprintf("%s %d", protocol.FailAuth, reqID)
+						return
+					}
+					inc <- fmt.Sprintf("%s %d", protocol.SuccAuth, reqID)
+				})
+			default:
+				t.Errorf("fake master: unexpected message '%s'", msg)
+			}
+		}
+	}()
+	return
+}
+
+var errTimeout = errors.New("timed out")
+
+// authenticate runs a full authentication through m, answering the challenge with priv. It is safe to call from
+// multiple goroutines.
+func authenticate(t *testing.T, m *Manager, domain, name string, priv PrivateKey) confirmResult {
+	chals := make(chan challengeResult, 1)
+	results := make(chan confirmResult, 1)
+
+	m.TryAuthentication(domain, name,
+		func(reqID uint32, chal string) {
+			chals <- challengeResult{reqID: reqID, chal: chal}
+		},
+		func(rol Role) { results <- confirmResult{rol: rol} },
+		func(err error) { results <- confirmResult{err: err} },
+	)
+
+	select {
+	case c := <-chals:
+		answ, err := Solve(c.chal, priv)
+		if err != nil {
+			t.Errorf("solving challenge: %v", err)
+			return confirmResult{err: err}
+		}
+		err = m.CheckAnswer(c.reqID, answ)
+		if err != nil {
+			return confirmResult{err: err}
+		}
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Errorf("authenticating '%s' in domain '%s': timed out waiting for challenge", name, domain)
+		return confirmResult{err: errTimeout}
+	}
+
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Errorf("authenticating '%s' in domain '%s': timed out waiting for result", name, domain)
+		return confirmResult{err: errTimeout}
+	}
+}
+
+func TestManager(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+	wrongPriv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	users := []*User{
+		{Name: "admin", PublicKey: pub, Role: RoleAdmin},
+		{Name: "player", PublicKey: pub, Role: RoleNone},
+	}
+
+	inc, out := fakeMaster(t, NewInMemoryProvider(users))
+	defer close(out)
+
+	m := NewManager(
+		map[string]Provider{
+			"":       NewInMemoryProvider(users),
+			"remote": NewRemoteProvider(inc, out, RoleAuth),
+		},
+		map[string]Role{
+			"": RoleMaster,
+		},
+	)
+
+	tests := []struct {
+		domain, name string
+		priv         PrivateKey
+		rol          Role
+		fail         bool
+	}{
+		{domain: "", name: "admin", priv: priv, rol: RoleAdmin},
+		{domain: "", name: "player", priv: priv, rol: RoleMaster},
+		{domain: "", name: "admin", priv: wrongPriv, fail: true},
+		{domain: "", name: "nobody", priv: priv, fail: true},
+		{domain: "remote", name: "admin", priv: priv, rol: RoleAuth},
+		{domain: "remote", name: "player", priv: priv, rol: RoleAuth},
+		{domain: "remote", name: "admin", priv: wrongPriv, fail: true},
+		{domain: "remote", name: "nobody", priv: priv, fail: true},
+		{domain: "unknown", name: "admin", priv: priv, fail: true},
+	}
+
+	for _, test := range tests {
+		r := authenticate(t, m, test.domain, test.name, test.priv)
+		if test.fail {
+			if r.err == nil {
+				t.Errorf("authenticating '%s' in domain '%s': expected failure, got role %s", test.name, test.domain, r.rol)
+			}
+			continue
+		}
+		if r.err != nil {
+			t.Errorf("authenticating '%s' in domain '%s': %v", test.name, test.domain, r.err)
+			continue
+		}
+		if r.rol != test.rol {
+			t.Errorf("authenticating '%s' in domain '%s': expected role %s, got %s", test.name, test.domain, test.rol, r.rol)
+		}
+	}
+}
+
+func TestManagerContext(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	users := []*User{{Name: "admin", PublicKey: pub, Role: RoleAdmin}}
+
+	inc, out := fakeMaster(t, NewInMemoryProvider(users))
+	defer close(out)
+
+	m := NewManager(
+		map[string]Provider{
+			"":       NewInMemoryProvider(users),
+			"remote": NewRemoteProvider(inc, out, RoleAuth),
+		},
+		nil,
+	)
+
+	for domain, expected := range map[string]Role{"": RoleAdmin, "remote": RoleAuth} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		reqID, chal, err := m.Challenge(ctx, domain, "admin")
+		if err != nil {
+			t.Fatalf("requesting challenge in domain '%s': %v", domain, err)
+		}
+		answ, err := Solve(chal, priv)
+		if err != nil {
+			t.Fatalf("solving challenge: %v", err)
+		}
+		rol, err := m.Confirm(ctx, reqID, answ)
+		if err != nil {
+			t.Errorf("confirming answer in domain '%s': %v", domain, err)
+		} else if rol != expected {
+			t.Errorf("confirming answer in domain '%s': expected role %s, got %s", domain, expected, rol)
+		}
+
+		cancel()
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	_, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	// a master that never answers
+	out := make(chan string)
+	go func() {
+		for range out {
+		}
+	}()
+	defer close(out)
+
+	rp := NewRemoteProvider(make(chan string), out, RoleAuth)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = rp.Challenge(ctx, "admin")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline to be exceeded, got %v", err)
+	}
+	if n := len(rp.requestChallengeCallbacks) + len(rp.lastActivity); n != 0 {
+		t.Errorf("expected aborted request to be cleaned up, %d entries remain", n)
+	}
+
+	ip := NewInMemoryProvider([]*User{{Name: "admin", PublicKey: pub}})
+	reqID, _, err := ip.Challenge(context.Background(), "admin")
+	if err != nil {
+		t.Fatalf("requesting challenge: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ip.Confirm(cancelled, reqID, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context to be cancelled, got %v", err)
+	}
+	if n := len(ip.pendingRequests); n != 0 {
+		t.Errorf("expected aborted request to be cleaned up, %d entries remain", n)
+	}
+}
+
+// run with -race
+func TestManagerConcurrency(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+	wrongPriv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	users := []*User{{Name: "admin", PublicKey: pub, Role: RoleAdmin}}
+
+	inc, out := fakeMaster(t, NewInMemoryProvider(users))
+	defer close(out)
+
+	// both providers allocate request IDs starting at 0, so this also checks that their requests are kept apart
+	m := NewManager(
+		map[string]Provider{
+			"":       NewInMemoryProvider(users),
+			"remote": NewRemoteProvider(inc, out, RoleAuth),
+		},
+		nil,
+	)
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		domain, expected := "", RoleAdmin
+		if i%2 == 1 {
+			domain, expected = "remote", RoleAuth
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%5 == 0 {
+				r := authenticate(t, m, domain, "admin", wrongPriv)
+				if r.err == nil {
+					t.Errorf("domain '%s': wrong key was accepted", domain)
+				}
+				return
+			}
+			r := authenticate(t, m, domain, "admin", priv)
+			if r.err != nil {
+				t.Errorf("domain '%s': %v", domain, r.err)
+			} else if r.rol != expected {
+				t.Errorf("domain '%s': expected role %s, got %s", domain, expected, r.rol)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChallengeExpiry(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	p := newInMemoryProvider([]*User{{Name: "admin", PublicKey: pub}}, 5*time.Millisecond)
+	defer p.Close()
+	p.SetChallengeLifetime(10 * time.Millisecond)
+
+	reqID, chal, err := p.Challenge(context.Background(), "admin")
+	if err != nil {
+		t.Fatalf("requesting challenge: %v", err)
+	}
+	answ, err := Solve(chal, priv)
+	if err != nil {
+		t.Fatalf("solving challenge: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := p.Confirm(context.Background(), reqID, answ); err == nil {
+		t.Error("answer to expired challenge was accepted")
+	}
+
+	// expired requests that are never answered are dropped, even if no new ones come in
+	for range 3 {
+		if _, _, err := p.Challenge(context.Background(), "admin"); err != nil {
+			t.Fatalf("requesting challenge: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	p.mutex.Lock()
+	n := len(p.pendingRequests)
+	p.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("expected expired requests to be dropped, %d requests pending", n)
+	}
+}
+
+func TestManagerRequestExpiry(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("generating key pair: %v", err)
+	}
+
+	p := NewInMemoryProvider([]*User{{Name: "admin", PublicKey: pub}})
+	defer p.Close()
+	m := newManager(map[string]Provider{"": p}, nil, 5*time.Millisecond)
+	defer m.Close()
+	m.SetRequestLifetime(10 * time.Millisecond)
+
+	reqID, chal, err := m.Challenge(context.Background(), "", "admin")
+	if err != nil {
+		t.Fatalf("requesting challenge: %v", err)
+	}
+	answ, err := Solve(chal, priv)
+	if err != nil {
+		t.Fatalf("solving challenge: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := m.Confirm(context.Background(), reqID, answ); err == nil {
+		t.Error("answer to expired request was accepted")
+	}
+
+	// requests that are never answered fail and are dropped, even if no new ones come in
+	failures := make(chan error, 1)
+	m.TryAuthentication("", "admin", func(uint32, string) {}, func(Role) {}, func(err error) { failures <- err })
+	select {
+	case <-failures:
+	case <-time.After(5 * time.Second):
+		t.Error("expired request did not fail")
+	}
+	m.mutex.Lock()
+	n := len(m.requests)
+	m.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("expected expired requests to be dropped, %d requests pending", n)
+	}
+}
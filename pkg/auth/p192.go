@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+// This file implements the P-192 curve arithmetic needed for Sauerbraten's auth mechanism. All operations on secret
+// values (scalars and the points derived from them) run in constant time: there are no branches or memory accesses
+// that depend on secret data.
+
+// curveParams holds the P-192 domain parameters as big integers, for parsing and validation.
+type curveParams struct {
+	P, N, B, Gx, Gy *big.Int
+	BitSize         int
+}
+
+var p192 = func() *curveParams {
+	c := &curveParams{BitSize: 192}
+	c.P, _ = new(big.Int).SetString("6277101735386680763835789423207666416083908700390324961279", 10)
+	c.N, _ = new(big.Int).SetString("6277101735386680763835789423176059013767194773182842284081", 10)
+	c.B, _ = new(big.Int).SetString("64210519e59c80e70fa7e9ab72243049feb8deecc146b9b1", 16)
+	c.Gx, _ = new(big.Int).SetString("188da80eb03090f67cbf20eb43a18800f4ff0afd82ff1012", 16)
+	c.Gy, _ = new(big.Int).SetString("07192b95ffc8da78631011ed6b24cdd573f977a11e794811", 16)
+	return c
+}()
+
+// fieldElement is an element of GF(p) with p = 2^192 - 2^64 - 1, as three 64-bit limbs, least significant limb first.
+// Field elements are always fully reduced.
+type fieldElement [3]uint64
+
+var (
+	feP = fieldElement{0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFE, 0xFFFFFFFFFFFFFFFF}
+	feB = fieldElementFromBig(p192.B)
+)
+
+func fieldElementFromBig(x *big.Int) (e fieldElement) {
+	var buf [24]byte
+	new(big.Int).Mod(x, p192.P).FillBytes(buf[:])
+	for i := range e {
+		for _, b := range buf[24-8*(i+1) : 24-8*i] {
+			e[i] = e[i]<<8 | uint64(b)
+		}
+	}
+	return
+}
+
+func (e fieldElement) big() *big.Int {
+	var buf [24]byte
+	for i, limb := range e {
+		for j := range 8 {
+			buf[23-8*i-j] = byte(limb >> (8 * j))
+		}
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
+
+// feSelect returns b if cond is 1 and a if cond is 0.
+func feSelect(a, b fieldElement, cond uint64) fieldElement {
+	mask := -cond
+	for i := range a {
+		a[i] ^= mask & (a[i] ^ b[i])
+	}
+	return a
+}
+
+// feReduceOnce subtracts p from a (given as three limbs plus a carry bit) if a >= p.
+func feReduceOnce(a fieldElement, carry uint64) fieldElement {
+	var t fieldElement
+	var borrow uint64
+	t[0], borrow = bits.Sub64(a[0], feP[0], 0)
+	t[1], borrow = bits.Sub64(a[1], feP[1], borrow)
+	t[2], borrow = bits.Sub64(a[2], feP[2], borrow)
+	// a >= p if subtracting p didn't borrow, or if a had a carry bit set
+	return feSelect(a, t, carry|(borrow^1))
+}
+
+func feAdd(a, b fieldElement) (r fieldElement) {
+	var carry uint64
+	r[0], carry = bits.Add64(a[0], b[0], 0)
+	r[1], carry = bits.Add64(a[1], b[1], carry)
+	r[2], carry = bits.Add64(a[2], b[2], carry)
+	return feReduceOnce(r, carry)
+}
+
+func feSub(a, b fieldElement) (r fieldElement) {
+	var borrow, carry uint64
+	r[0], borrow = bits.Sub64(a[0], b[0], 0)
+	r[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	r[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	// add p back if we went below zero
+	mask := -borrow
+	r[0], carry = bits.Add64(r[0], feP[0]&mask, 0)
+	r[1], carry = bits.Add64(r[1], feP[1]&mask, carry)
+	r[2], _ = bits.Add64(r[2], feP[2]&mask, carry)
+	return
+}
+
+func feMul(a, b fieldElement) fieldElement {
+	var c [6]uint64
+	for i := range a {
+		var carry uint64
+		for j := range b {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var cc uint64
+			lo, cc = bits.Add64(lo, c[i+j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, carry, 0)
+			hi += cc
+			c[i+j] = lo
+			carry = hi
+		}
+		c[i+3] = carry
+	}
+	return feReduce(&c)
+}
+
+func feSquare(a fieldElement) fieldElement {
+	return feMul(a, a)
+}
+
+// feReduce reduces a 384-bit product modulo p, using 2^192 ≡ 2^64 + 1 (mod p). See "Routines for Efficient
+// Implementation of Modular Arithmetic for the NIST Primes" in FIPS 186-4, appendix D.2.1.
+func feReduce(c *[6]uint64) fieldElement {
+	var r fieldElement
+	var carry, k uint64
+
+	// r = (c2, c1, c0) + (0, c3, c3) + (c4, c4, 0) + (c5, c5, c5)
+	r[0], carry = bits.Add64(c[0], c[3], 0)
+	r[1], carry = bits.Add64(c[1], c[3], carry)
+	r[2], carry = bits.Add64(c[2], 0, carry)
+	k += carry
+
+	r[1], carry = bits.Add64(r[1], c[4], 0)
+	r[2], carry = bits.Add64(r[2], c[4], carry)
+	k += carry
+
+	r[0], carry = bits.Add64(r[0], c[5], 0)
+	r[1], carry = bits.Add64(r[1], c[5], carry)
+	r[2], carry = bits.Add64(r[2], c[5], carry)
+	k += carry
+
+	// fold the overflow k*2^192 back in as k*(2^64 + 1); doing this twice is always enough
+	for range 2 {
+		r[0], carry = bits.Add64(r[0], k, 0)
+		r[1], carry = bits.Add64(r[1], k, carry)
+		r[2], carry = bits.Add64(r[2], 0, carry)
+		k = carry
+	}
+
+	return feReduceOnce(r, 0)
+}
+
+// feInvert returns 1/a using Fermat's little theorem (a^(p-2)). The exponent is public, so this is constant time.
+// The inverse of zero is zero.
+func feInvert(a fieldElement) fieldElement {
+	// p - 2
+	e := fieldElement{0xFFFFFFFFFFFFFFFD, 0xFFFFFFFFFFFFFFFE, 0xFFFFFFFFFFFFFFFF}
+	r := fieldElement{1, 0, 0}
+	for i := 191; i >= 0; i-- {
+		r = feSquare(r)
+		if (e[i/64]>>(i%64))&1 == 1 {
+			r = feMul(r, a)
+		}
+	}
+	return r
+}
+
+// point is a point on P-192 in projective coordinates (X:Y:Z), representing the affine point (X/Z, Y/Z). The point at
+// infinity is (0:1:0).
+type point struct {
+	x, y, z fieldElement
+}
+
+func newIdentityPoint() point {
+	return point{y: fieldElement{1, 0, 0}}
+}
+
+func newPointFromAffine(x, y *big.Int) point {
+	return point{
+		x: fieldElementFromBig(x),
+		y: fieldElementFromBig(y),
+		z: fieldElement{1, 0, 0},
+	}
+}
+
+// affine returns the affine coordinates of p. The point at infinity is returned as (0, 0).
+func (p point) affine() (x, y *big.Int) {
+	zInv := feInvert(p.z)
+	return feMul(p.x, zInv).big(), feMul(p.y, zInv).big()
+}
+
+// pointSelect returns b if cond is 1 and a if cond is 0.
+func pointSelect(a, b point, cond uint64) point {
+	return point{
+		x: feSelect(a.x, b.x, cond),
+		y: feSelect(a.y, b.y, cond),
+		z: feSelect(a.z, b.z, cond),
+	}
+}
+
+// pointAdd returns p + q using the complete addition formula for a = -3 from "Complete addition formulas for prime
+// order elliptic curves" (https://eprint.iacr.org/2015/1060), algorithm 4. It works for all inputs, including equal
+// points and the point at infinity.
+func pointAdd(p, q point) point {
+	t0 := feMul(p.x, q.x) // t0 := X1 * X2
+	t1 := feMul(p.y, q.y) // t1 := Y1 * Y2
+	t2 := feMul(p.z, q.z) // t2 := Z1 * Z2
+	t3 := feAdd(p.x, p.y) // t3 := X1 + Y1
+	t4 := feAdd(q.x, q.y) // t4 := X2 + Y2
+	t3 = feMul(t3, t4)    // t3 := t3 * t4
+	t4 = feAdd(t0, t1)    // t4 := t0 + t1
+	t3 = feSub(t3, t4)    // t3 := t3 - t4
+	t4 = feAdd(p.y, p.z)  // t4 := Y1 + Z1
+	x3 := feAdd(q.y, q.z) // X3 := Y2 + Z2
+	t4 = feMul(t4, x3)    // t4 := t4 * X3
+	x3 = feAdd(t1, t2)    // X3 := t1 + t2
+	t4 = feSub(t4, x3)    // t4 := t4 - X3
+	x3 = feAdd(p.x, p.z)  // X3 := X1 + Z1
+	y3 := feAdd(q.x, q.z) // Y3 := X2 + Z2
+	x3 = feMul(x3, y3)    // X3 := X3 * Y3
+	y3 = feAdd(t0, t2)    // Y3 := t0 + t2
+	y3 = feSub(x3, y3)    // Y3 := X3 - Y3
+	z3 := feMul(feB, t2)  // Z3 := b * t2
+	x3 = feSub(y3, z3)    // X3 := Y3 - Z3
+	z3 = feAdd(x3, x3)    // Z3 := X3 + X3
+	x3 = feAdd(x3, z3)    // X3 := X3 + Z3
+	z3 = feSub(t1, x3)    // Z3 := t1 - X3
+	x3 = feAdd(t1, x3)    // X3 := t1 + X3
+	y3 = feMul(feB, y3)   // Y3 := b * Y3
+	t1 = feAdd(t2, t2)    // t1 := t2 + t2
+	t2 = feAdd(t1, t2)    // t2 := t1 + t2
+	y3 = feSub(y3, t2)    // Y3 := Y3 - t2
+	y3 = feSub(y3, t0)    // Y3 := Y3 - t0
+	t1 = feAdd(y3, y3)    // t1 := Y3 + Y3
+	y3 = feAdd(t1, y3)    // Y3 := t1 + Y3
+	t1 = feAdd(t0, t0)    // t1 := t0 + t0
+	t0 = feAdd(t1, t0)    // t0 := t1 + t0
+	t0 = feSub(t0, t2)    // t0 := t0 - t2
+	t1 = feMul(t4, y3)    // t1 := t4 * Y3
+	t2 = feMul(t0, y3)    // t2 := t0 * Y3
+	y3 = feMul(x3, z3)    // Y3 := X3 * Z3
+	y3 = feAdd(y3, t2)    // Y3 := Y3 + t2
+	x3 = feMul(t3, x3)    // X3 := t3 * X3
+	x3 = feSub(x3, t1)    // X3 := X3 - t1
+	z3 = feMul(t4, z3)    // Z3 := t4 * Z3
+	t1 = feMul(t3, t0)    // t1 := t3 * t0
+	z3 = feAdd(z3, t1)    // Z3 := Z3 + t1
+	return point{x3, y3, z3}
+}
+
+// pointDouble returns 2p using the exception-free doubling formula for a = -3 from the same paper, algorithm 6.
+func pointDouble(p point) point {
+	t0 := feSquare(p.x)   // t0 := X ^ 2
+	t1 := feSquare(p.y)   // t1 := Y ^ 2
+	t2 := feSquare(p.z)   // t2 := Z ^ 2
+	t3 := feMul(p.x, p.y) // t3 := X * Y
+	t3 = feAdd(t3, t3)    // t3 := t3 + t3
+	z3 := feMul(p.x, p.z) // Z3 := X * Z
+	z3 = feAdd(z3, z3)    // Z3 := Z3 + Z3
+	y3 := feMul(feB, t2)  // Y3 := b * t2
+	y3 = feSub(y3, z3)    // Y3 := Y3 - Z3
+	x3 := feAdd(y3, y3)   // X3 := Y3 + Y3
+	y3 = feAdd(x3, y3)    // Y3 := X3 + Y3
+	x3 = feSub(t1, y3)    // X3 := t1 - Y3
+	y3 = feAdd(t1, y3)    // Y3 := t1 + Y3
+	y3 = feMul(x3, y3)    // Y3 := X3 * Y3
+	x3 = feMul(x3, t3)    // X3 := X3 * t3
+	t3 = feAdd(t2, t2)    // t3 := t2 + t2
+	t2 = feAdd(t2, t3)    // t2 := t2 + t3
+	z3 = feMul(feB, z3)   // Z3 := b * Z3
+	z3 = feSub(z3, t2)    // Z3 := Z3 - t2
+	z3 = feSub(z3, t0)    // Z3 := Z3 - t0
+	t3 = feAdd(z3, z3)    // t3 := Z3 + Z3
+	z3 = feAdd(z3, t3)    // Z3 := Z3 + t3
+	t3 = feAdd(t0, t0)    // t3 := t0 + t0
+	t0 = feAdd(t3, t0)    // t0 := t3 + t0
+	t0 = feSub(t0, t2)    // t0 := t0 - t2
+	t0 = feMul(t0, z3)    // t0 := t0 * Z3
+	y3 = feAdd(y3, t0)    // Y3 := Y3 + t0
+	t0 = feMul(p.y, p.z)  // t0 := Y * Z
+	t0 = feAdd(t0, t0)    // t0 := t0 + t0
+	z3 = feMul(t0, z3)    // Z3 := t0 * Z3
+	x3 = feSub(x3, z3)    // X3 := X3 - Z3
+	z3 = feMul(t0, t1)    // Z3 := t0 * t1
+	z3 = feAdd(z3, z3)    // Z3 := Z3 + Z3
+	z3 = feAdd(z3, z3)    // Z3 := Z3 + Z3
+	return point{x3, y3, z3}
+}
+
+// pointTable holds 0*P, 1*P, ..., 15*P for some point P.
+type pointTable [16]point
+
+func newPointTable(p point) (t pointTable) {
+	t[0] = newIdentityPoint()
+	t[1] = p
+	for i := 2; i < len(t); i++ {
+		t[i] = pointAdd(t[i-1], p)
+	}
+	return
+}
+
+// lookup returns t[n] without leaking n through timing or memory access patterns.
+func (t *pointTable) lookup(n byte) point {
+	r := newIdentityPoint()
+	for i := range t {
+		// 1 if i == n, 0 otherwise
+		eq := uint64(1 ^ ((uint32(byte(i)^n) | -uint32(byte(i)^n)) >> 31))
+		r = pointSelect(r, t[i], eq)
+	}
+	return r
+}
+
+// scalarBytes returns k as fixed-size big-endian number. Scalars longer than 24 bytes are reduced modulo N first (not
+// in constant time, but valid private keys are never that long).
+func scalarBytes(k []byte) (s [24]byte) {
+	if len(k) > len(s) {
+		new(big.Int).Mod(new(big.Int).SetBytes(k), p192.N).FillBytes(s[:])
+		return
+	}
+	copy(s[len(s)-len(k):], k)
+	return
+}
+
+// scalarMult returns k*p, using a fixed 4-bit window.
+func scalarMult(p point, k []byte) point {
+	table := newPointTable(p)
+	s := scalarBytes(k)
+
+	r := newIdentityPoint()
+	for _, b := range s {
+		for range 4 {
+			r = pointDouble(r)
+		}
+		r = pointAdd(r, table.lookup(b>>4))
+		for range 4 {
+			r = pointDouble(r)
+		}
+		r = pointAdd(r, table.lookup(b&0xF))
+	}
+	return r
+}
+
+var (
+	baseTablesOnce sync.Once
+	baseTables     [48]pointTable // baseTables[i][j] = j * 16^i * G
+)
+
+// scalarBaseMult returns k*G, using precomputed multiples of the base point, so no doublings are needed.
+func scalarBaseMult(k []byte) point {
+	baseTablesOnce.Do(func() {
+		p := newPointFromAffine(p192.Gx, p192.Gy)
+		for i := range baseTables {
+			baseTables[i] = newPointTable(p)
+			for range 4 {
+				p = pointDouble(p)
+			}
+		}
+	})
+
+	s := scalarBytes(k)
+
+	r := newIdentityPoint()
+	for i, b := range s {
+		// s is big-endian, baseTables[0] is for the least significant nibble
+		r = pointAdd(r, baseTables[2*(len(s)-1-i)+1].lookup(b>>4))
+		r = pointAdd(r, baseTables[2*(len(s)-1-i)].lookup(b&0xF))
+	}
+	return r
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// reference implementations using math/big, to check the constant-time arithmetic against
+
+func refAdd(x1, y1, x2, y2 *big.Int) (x3, y3 *big.Int) {
+	P := p192.P
+	if x1 == nil {
+		return x2, y2
+	}
+	if x2 == nil {
+		return x1, y1
+	}
+	var l *big.Int
+	if x1.Cmp(x2) == 0 {
+		if new(big.Int).Add(y1, y2).Mod(new(big.Int).Add(y1, y2), P).Sign() == 0 {
+			return nil, nil // point at infinity
+		}
+		// l = (3x^2 - 3) / 2y
+		num := new(big.Int).Mul(x1, x1)
+		num.Sub(num, big.NewInt(1)).Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(y1, 1)
+		l = num.Mul(num, den.ModInverse(den, P))
+	} else {
+		// l = (y2 - y1) / (x2 - x1)
+		num := new(big.Int).Sub(y2, y1)
+		den := new(big.Int).Sub(x2, x1)
+		den.Mod(den, P)
+		l = num.Mul(num, den.ModInverse(den, P))
+	}
+	l.Mod(l, P)
+	x3 = new(big.Int).Mul(l, l)
+	x3.Sub(x3, x1).Sub(x3, x2).Mod(x3, P)
+	y3 = new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, l).Sub(y3, y1).Mod(y3, P)
+	return
+}
+
+func refScalarMult(x, y *big.Int, k []byte) (rx, ry *big.Int) {
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			rx, ry = refAdd(rx, ry, rx, ry)
+			if (b>>bit)&1 == 1 {
+				rx, ry = refAdd(rx, ry, x, y)
+			}
+		}
+	}
+	if rx == nil {
+		return new(big.Int), new(big.Int)
+	}
+	return
+}
+
+func randomFieldElement(t testing.TB) *big.Int {
+	n, err := rand.Int(rand.Reader, p192.P)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	P := p192.P
+	edge := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(2),
+		new(big.Int).Sub(P, big.NewInt(1)),
+		new(big.Int).Sub(P, big.NewInt(2)),
+		new(big.Int).Lsh(big.NewInt(1), 64),
+		new(big.Int).Lsh(big.NewInt(1), 128),
+		new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 191), big.NewInt(1)),
+	}
+	values := append([]*big.Int{}, edge...)
+	for range 50 {
+		values = append(values, randomFieldElement(t))
+	}
+
+	for _, a := range values {
+		fa := fieldElementFromBig(a)
+		if fa.big().Cmp(a) != 0 {
+			t.Fatalf("%x does not round-trip", a)
+		}
+		for _, b := range values {
+			fb := fieldElementFromBig(b)
+
+			sum := new(big.Int).Add(a, b)
+			if got := feAdd(fa, fb).big(); got.Cmp(sum.Mod(sum, P)) != 0 {
+				t.Errorf("%x + %x: expected %x, got %x", a, b, sum, got)
+			}
+			diff := new(big.Int).Sub(a, b)
+			if got := feSub(fa, fb).big(); got.Cmp(diff.Mod(diff, P)) != 0 {
+				t.Errorf("%x - %x: expected %x, got %x", a, b, diff, got)
+			}
+			prod := new(big.Int).Mul(a, b)
+			if got := feMul(fa, fb).big(); got.Cmp(prod.Mod(prod, P)) != 0 {
+				t.Errorf("%x * %x: expected %x, got %x", a, b, prod, got)
+			}
+		}
+		inv := new(big.Int).ModInverse(a, P)
+		if inv == nil {
+			inv = new(big.Int)
+		}
+		if got := feInvert(fa).big(); got.Cmp(inv) != 0 {
+			t.Errorf("1 / %x: expected %x, got %x", a, inv, got)
+		}
+	}
+}
+
+func TestScalarMult(t *testing.T) {
+	scalars := [][]byte{
+		{0},
+		{1},
+		{2},
+		{15},
+		{16},
+		new(big.Int).Sub(p192.N, big.NewInt(1)).Bytes(),
+		p192.N.Bytes(),
+		new(big.Int).Add(p192.N, big.NewInt(1)).Bytes(),
+		append(make([]byte, 24), 1, 2, 3), // longer than 24 bytes
+	}
+	for range 20 {
+		k, err := randomScalar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		scalars = append(scalars, k)
+	}
+
+	_, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range scalars {
+		ex, ey := refScalarMult(p192.Gx, p192.Gy, k)
+		x, y := scalarBaseMult(k).affine()
+		if x.Cmp(ex) != 0 || y.Cmp(ey) != 0 {
+			t.Errorf("%x * G: expected (%x, %x), got (%x, %x)", k, ex, ey, x, y)
+		}
+
+		ex, ey = refScalarMult(pub.x, pub.y, k)
+		x, y = scalarMult(newPointFromAffine(pub.x, pub.y), k).affine()
+		if x.Cmp(ex) != 0 || y.Cmp(ey) != 0 {
+			t.Errorf("%x * %s: expected (%x, %x), got (%x, %x)", k, pub, ex, ey, x, y)
+		}
+	}
+}
+
+func TestPointDouble(t *testing.T) {
+	g := newPointFromAffine(p192.Gx, p192.Gy)
+	for _, p := range []point{newIdentityPoint(), g, pointDouble(g), pointAdd(g, pointDouble(g))} {
+		dx, dy := pointDouble(p).affine()
+		ax, ay := pointAdd(p, p).affine()
+		if dx.Cmp(ax) != 0 || dy.Cmp(ay) != 0 {
+			t.Errorf("doubling and adding to itself disagree: (%x, %x) vs. (%x, %x)", dx, dy, ax, ay)
+		}
+	}
+}
+
+func BenchmarkGenerateChallenge(b *testing.B) {
+	_, pub, err := GenerateKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _, err := GenerateChallenge(pub)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSolve(b *testing.B) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	chal, _, err := GenerateChallenge(pub)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		_, err := Solve(chal, priv)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScalarBaseMult(b *testing.B) {
+	k, err := randomScalar()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		scalarBaseMult(k)
+	}
+}
+
+func BenchmarkScalarMult(b *testing.B) {
+	k, err := randomScalar()
+	if err != nil {
+		b.Fatal(err)
+	}
+	g := newPointFromAffine(p192.Gx, p192.Gy)
+	for b.Loop() {
+		scalarMult(g, k)
+	}
+}
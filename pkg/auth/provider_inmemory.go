@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/sauerbraten/maitred/v2/pkg/protocol"
 )
@@ -9,58 +12,168 @@ import (
 // request holds the data we need to remember between
 // generating a challenge and checking the response.
 type request struct {
-	id       uint32
-	user     *User
-	solution string
+	id        uint32
+	user      *User
+	solution  string
+	createdAt time.Time
 }
 
+// DefaultChallengeLifetime is how long an InMemoryProvider accepts answers to a challenge unless configured otherwise.
+const DefaultChallengeLifetime = 30 * time.Second
+
+// how often an InMemoryProvider or a Manager drops expired requests
+const sweepInterval = 10 * time.Second
+
+// InMemoryProvider is safe for concurrent use. Call Close when it is no longer needed.
 type InMemoryProvider struct {
-	ids             *protocol.IDCycle
-	usersByName     map[string]*User
-	pendingRequests map[uint32]*request
+	usersByName map[string]*User
+	stop        chan struct{}
+	closeOnce   sync.Once
+
+	mutex             sync.Mutex // guards the fields below
+	ids               *protocol.IDCycle
+	pendingRequests   map[uint32]*request
+	challengeLifetime time.Duration
 }
 
 func NewInMemoryProvider(users []*User) *InMemoryProvider {
+	return newInMemoryProvider(users, sweepInterval)
+}
+
+func newInMemoryProvider(users []*User, sweepInterval time.Duration) *InMemoryProvider {
 	p := &InMemoryProvider{
-		ids:             new(protocol.IDCycle),
-		usersByName:     map[string]*User{},
-		pendingRequests: map[uint32]*request{},
+		usersByName:       map[string]*User{},
+		stop:              make(chan struct{}),
+		ids:               new(protocol.IDCycle),
+		pendingRequests:   map[uint32]*request{},
+		challengeLifetime: DefaultChallengeLifetime,
 	}
 	for _, u := range users {
 		p.usersByName[u.Name] = u
 	}
+	go p.run(sweepInterval)
 	return p
 }
 
+func (p *InMemoryProvider) run(sweepInterval time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweep()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Close stops sweeping expired requests. Pending requests can still be answered.
+func (p *InMemoryProvider) Close() {
+	p.closeOnce.Do(func() { close(p.stop) })
+}
+
+// SetChallengeLifetime sets how long answers to a challenge are accepted. Expired requests are dropped.
+func (p *InMemoryProvider) SetChallengeLifetime(d time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.challengeLifetime = d
+}
+
 func (p *InMemoryProvider) GenerateChallenge(name string, callback func(uint32, string, error)) {
+	callback(p.newRequest(name))
+}
+
+func (p *InMemoryProvider) ConfirmAnswer(reqID uint32, answ string, callback func(Role, error)) {
+	callback(p.checkAnswer(reqID, answ))
+}
+
+func (p *InMemoryProvider) Challenge(ctx context.Context, name string) (uint32, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	reqID, chal, err := p.newRequest(name)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := ctx.Err(); err != nil {
+		p.forget(reqID)
+		return 0, "", err
+	}
+	return reqID, chal, nil
+}
+
+func (p *InMemoryProvider) Confirm(ctx context.Context, reqID uint32, answ string) (Role, error) {
+	if err := ctx.Err(); err != nil {
+		p.forget(reqID)
+		return RoleNone, err
+	}
+	return p.checkAnswer(reqID, answ)
+}
+
+func (p *InMemoryProvider) newRequest(name string) (uint32, string, error) {
 	u, ok := p.usersByName[name]
 	if !ok {
-		callback(0, "", errors.New("auth: user not found"))
-		return
+		return 0, "", errors.New("auth: user not found")
 	}
 
-	req := &request{
-		id:   p.ids.Next(),
-		user: u,
+	chal, sol, err := GenerateChallenge(u.PublicKey)
+	if err != nil {
+		return 0, "", err
 	}
 
-	chal, sol, err := GenerateChallenge(u.PublicKey)
-	if err == nil {
-		req.solution = sol
-		p.pendingRequests[req.id] = req
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	req := &request{
+		id:        p.ids.Next(),
+		user:      u,
+		solution:  sol,
+		createdAt: time.Now(),
 	}
-	callback(req.id, chal, err)
+	p.pendingRequests[req.id] = req
+
+	return req.id, chal, nil
 }
 
-func (p *InMemoryProvider) ConfirmAnswer(reqID uint32, answ string, callback func(Role, error)) {
+func (p *InMemoryProvider) checkAnswer(reqID uint32, answ string) (Role, error) {
+	p.mutex.Lock()
 	req, ok := p.pendingRequests[reqID]
+	delete(p.pendingRequests, reqID)
+	lifetime := p.challengeLifetime
+	p.mutex.Unlock()
+
+	if !ok {
+		return RoleNone, errors.New("auth: request not found")
+	}
+	if time.Since(req.createdAt) > lifetime {
+		return RoleNone, errors.New("auth: challenge expired")
+	}
+	ok, err := VerifyAnswer(answ, req.solution)
+	if err != nil {
+		return RoleNone, err
+	}
 	if !ok {
-		callback(RoleNone, errors.New("auth: request not found"))
-		return
+		return RoleNone, ErrWrongAnswer
 	}
-	if answ != req.solution {
-		callback(RoleNone, errors.New("auth: wrong answer"))
-		return
+	return req.user.Role, nil
+}
+
+func (p *InMemoryProvider) forget(reqID uint32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.pendingRequests, reqID)
+}
+
+// sweep drops expired requests.
+func (p *InMemoryProvider) sweep() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for id, req := range p.pendingRequests {
+		if time.Since(req.createdAt) > p.challengeLifetime {
+			delete(p.pendingRequests, id)
+		}
 	}
-	callback(req.user.Role, nil)
 }
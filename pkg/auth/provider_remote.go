@@ -1,21 +1,26 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sauerbraten/maitred/v2/pkg/protocol"
 )
 
+// RemoteProvider is safe for concurrent use.
 type RemoteProvider struct {
 	// for communication with master
 	inc <-chan string
 	out chan<- string
 
-	rol                       Role // all successful auths will get this role in the ConfirmAnswer callback
+	rol Role // all successful auths will get this role in the ConfirmAnswer callback
+
+	mutex                     sync.Mutex // guards the fields below
 	ids                       *protocol.IDCycle
 	lastActivity              map[uint32]time.Time
 	requestChallengeCallbacks map[uint32]func(uint32, string, error)
@@ -38,28 +43,50 @@ func NewRemoteProvider(inc <-chan string, out chan<- string, rol Role) *RemotePr
 }
 
 func (p *RemoteProvider) run() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case msg := <-p.inc:
 			p.handle(msg)
-		case <-time.Tick(10 * time.Second):
-			timedOut := []uint32{}
-			for reqID, lastActive := range p.lastActivity {
-				if time.Since(lastActive) > 30*time.Second {
-					timedOut = append(timedOut, reqID)
-				}
-			}
-			for _, reqID := range timedOut {
-				if callback, ok := p.requestChallengeCallbacks[reqID]; ok {
-					callback(reqID, "", errors.New("timed out waiting for challenge"))
-				}
-				delete(p.requestChallengeCallbacks, reqID)
-				if callback, ok := p.confirmAnswerCallbacks[reqID]; ok {
-					callback(RoleNone, errors.New("timed out waiting for confirmation"))
-				}
-				delete(p.confirmAnswerCallbacks, reqID)
-				delete(p.lastActivity, reqID)
-			}
+		case <-ticker.C:
+			p.timeOutRequests()
+		}
+	}
+}
+
+func (p *RemoteProvider) timeOutRequests() {
+	type timedOut struct {
+		reqID  uint32
+		onChal func(uint32, string, error)
+		onConf func(Role, error)
+	}
+
+	p.mutex.Lock()
+	requests := []timedOut{}
+	for reqID, lastActive := range p.lastActivity {
+		if time.Since(lastActive) > 30*time.Second {
+			requests = append(requests, timedOut{
+				reqID:  reqID,
+				onChal: p.requestChallengeCallbacks[reqID],
+				onConf: p.confirmAnswerCallbacks[reqID],
+			})
+		}
+	}
+	for _, req := range requests {
+		delete(p.requestChallengeCallbacks, req.reqID)
+		delete(p.confirmAnswerCallbacks, req.reqID)
+		delete(p.lastActivity, req.reqID)
+	}
+	p.mutex.Unlock()
+
+	for _, req := range requests {
+		if req.onChal != nil {
+			req.onChal(req.reqID, "", errors.New("timed out waiting for challenge"))
+		}
+		if req.onConf != nil {
+			req.onConf(RoleNone, errors.New("timed out waiting for confirmation"))
 		}
 	}
 }
@@ -84,18 +111,89 @@ func (p *RemoteProvider) handle(msg string) {
 }
 
 func (p *RemoteProvider) GenerateChallenge(name string, callback func(reqID uint32, chal string, err error)) {
-	reqID := p.ids.Next()
+	reqID := p.awaitChallenge(callback)
 	p.out <- fmt.Sprintf("%s %d %s", protocol.ReqAuth, reqID, name)
-	p.requestChallengeCallbacks[reqID] = callback
-	p.lastActivity[reqID] = time.Now()
 }
 
 func (p *RemoteProvider) ConfirmAnswer(reqID uint32, answ string, callback func(Role, error)) {
+	p.awaitConfirmation(reqID, callback)
 	p.out <- fmt.Sprintf("%s %d %s", protocol.ConfAuth, reqID, answ)
+}
+
+func (p *RemoteProvider) Challenge(ctx context.Context, name string) (uint32, string, error) {
+	results := make(chan challengeResult, 1)
+	reqID := p.awaitChallenge(func(reqID uint32, chal string, err error) {
+		results <- challengeResult{reqID, chal, err}
+	})
+
+	select {
+	case p.out <- fmt.Sprintf("%s %d %s", protocol.ReqAuth, reqID, name):
+	case <-ctx.Done():
+		p.abort(reqID)
+		return 0, "", ctx.Err()
+	}
+
+	select {
+	case r := <-results:
+		return r.reqID, r.chal, r.err
+	case <-ctx.Done():
+		p.abort(reqID)
+		return 0, "", ctx.Err()
+	}
+}
+
+func (p *RemoteProvider) Confirm(ctx context.Context, reqID uint32, answ string) (Role, error) {
+	results := make(chan confirmResult, 1)
+	p.awaitConfirmation(reqID, func(rol Role, err error) {
+		results <- confirmResult{rol, err}
+	})
+
+	select {
+	case p.out <- fmt.Sprintf("%s %d %s", protocol.ConfAuth, reqID, answ):
+	case <-ctx.Done():
+		p.abort(reqID)
+		return RoleNone, ctx.Err()
+	}
+
+	select {
+	case r := <-results:
+		return r.rol, r.err
+	case <-ctx.Done():
+		p.abort(reqID)
+		return RoleNone, ctx.Err()
+	}
+}
+
+// awaitChallenge allocates a new request ID and registers callback to be called with the master's challenge.
+func (p *RemoteProvider) awaitChallenge(callback func(uint32, string, error)) uint32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reqID := p.ids.Next()
+	p.requestChallengeCallbacks[reqID] = callback
+	p.lastActivity[reqID] = time.Now()
+	return reqID
+}
+
+// awaitConfirmation registers callback to be called with the master's verdict on the answer to request reqID.
+func (p *RemoteProvider) awaitConfirmation(reqID uint32, callback func(Role, error)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	p.confirmAnswerCallbacks[reqID] = callback
 	p.lastActivity[reqID] = time.Now()
 }
 
+// abort forgets about a request, so that later messages from the master concerning it are ignored.
+func (p *RemoteProvider) abort(reqID uint32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.requestChallengeCallbacks, reqID)
+	delete(p.confirmAnswerCallbacks, reqID)
+	delete(p.lastActivity, reqID)
+}
+
 func (p *RemoteProvider) handleChalAuth(args string) {
 	var reqID uint32
 	var chal string
@@ -105,9 +203,12 @@ func (p *RemoteProvider) handleChalAuth(args string) {
 		return
 	}
 
-	defer delete(p.requestChallengeCallbacks, reqID)
+	p.mutex.Lock()
+	callback, ok := p.requestChallengeCallbacks[reqID]
+	delete(p.requestChallengeCallbacks, reqID)
+	p.mutex.Unlock()
 
-	if callback, ok := p.requestChallengeCallbacks[reqID]; ok {
+	if ok {
 		callback(reqID, chal, nil)
 	} else {
 		log.Printf("unsolicited %s message from remote provider: '%s'", protocol.ChalAuth, args)
@@ -122,9 +223,13 @@ func (p *RemoteProvider) handleSuccAuth(args string) {
 		return
 	}
 
-	defer delete(p.confirmAnswerCallbacks, reqID)
+	p.mutex.Lock()
+	callback, ok := p.confirmAnswerCallbacks[reqID]
+	delete(p.confirmAnswerCallbacks, reqID)
+	delete(p.lastActivity, reqID)
+	p.mutex.Unlock()
 
-	if callback, ok := p.confirmAnswerCallbacks[reqID]; ok {
+	if ok {
 		callback(p.rol, nil)
 	} else {
 		log.Printf("unsolicited %s message from remote provider: '%s'", protocol.SuccAuth, args)
@@ -139,15 +244,19 @@ func (p *RemoteProvider) handleFailAuth(args string) {
 		return
 	}
 
-	defer delete(p.confirmAnswerCallbacks, reqID)
+	p.mutex.Lock()
+	confCallback, confOK := p.confirmAnswerCallbacks[reqID]
+	delete(p.confirmAnswerCallbacks, reqID)
+	chalCallback, chalOK := p.requestChallengeCallbacks[reqID]
+	delete(p.requestChallengeCallbacks, reqID)
+	delete(p.lastActivity, reqID)
+	p.mutex.Unlock()
 
-	if callback, ok := p.confirmAnswerCallbacks[reqID]; ok {
-		callback(RoleNone, errors.New("remote auth provider signalled failure"))
+	if confOK {
+		confCallback(RoleNone, errors.New("remote auth provider signalled failure"))
+	} else if chalOK {
+		chalCallback(reqID, "", errors.New("remote auth provider signalled failure"))
 	} else {
-		if _, ok := p.requestChallengeCallbacks[reqID]; ok {
-			delete(p.requestChallengeCallbacks, reqID)
-		} else {
-			log.Printf("unsolicited %s message from remote provider: '%s'", protocol.FailAuth, args)
-		}
+		log.Printf("unsolicited %s message from remote provider: '%s'", protocol.FailAuth, args)
 	}
 }
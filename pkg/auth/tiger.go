@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Tiger hash (the original 3-pass version with 0x01 padding), as used by Sauerbraten's genauthkey command to derive a
+// private key from a secret string. Ported from shared/tiger.cpp.
+
+const tigerSize = 24
+
+var (
+	tigerSBoxesOnce sync.Once
+	tigerSBoxes     [4 * 256]uint64
+)
+
+var tigerInit = [3]uint64{0x0123456789ABCDEF, 0xFEDCBA9876543210, 0xF096A5B4C3B2E187}
+
+// tiger returns the Tiger hash of data, with the three 64-bit state words written in little-endian byte order.
+func tiger(data []byte) (hash [tigerSize]byte) {
+	tigerSBoxesOnce.Do(genTigerSBoxes)
+
+	state := tigerInit
+
+	n := len(data)
+	for ; len(data) >= 64; data = data[64:] {
+		tigerCompress(data, &state)
+	}
+
+	var block [64]byte
+	j := copy(block[:], data)
+	block[j] = 0x01
+	j++
+	if j > 56 {
+		tigerCompress(block[:], &state)
+		block = [64]byte{}
+	}
+	binary.LittleEndian.PutUint64(block[56:], uint64(n)<<3)
+	tigerCompress(block[:], &state)
+
+	for i, s := range state {
+		binary.LittleEndian.PutUint64(hash[8*i:], s)
+	}
+	return
+}
+
+// genTigerSBoxes generates the S-boxes the same way the reference implementation does. The compression function is
+// used on the S-boxes while they are still being generated.
+func genTigerSBoxes() {
+	const seed = "Tiger - A Fast New Hash Function, by Ross Anderson and Eli Biham"
+
+	state := tigerInit
+
+	for i := range tigerSBoxes {
+		tigerSBoxes[i] = uint64(i&255) * 0x0101010101010101
+	}
+
+	// returns byte col (little-endian order) of v
+	byteAt := func(v uint64, col int) uint64 { return (v >> (8 * col)) & 0xFF }
+	// sets byte col (little-endian order) of *v to b
+	setByte := func(v *uint64, col int, b uint64) { *v = *v&^(0xFF<<(8*col)) | b<<(8*col) }
+
+	abc := 2
+	for range 5 {
+		for i := range 256 {
+			for sb := 0; sb < 1024; sb += 256 {
+				abc++
+				if abc >= 3 {
+					abc = 0
+					tigerCompress([]byte(seed), &state)
+				}
+				for col := range 8 {
+					other := sb + int(byteAt(state[abc], col))
+					val := byteAt(tigerSBoxes[sb+i], col)
+					setByte(&tigerSBoxes[sb+i], col, byteAt(tigerSBoxes[other], col))
+					setByte(&tigerSBoxes[other], col, val)
+				}
+			}
+		}
+	}
+}
+
+// tigerCompress processes one 64-byte block.
+func tigerCompress(block []byte, state *[3]uint64) {
+	sb1, sb2, sb3, sb4 := tigerSBoxes[0:256], tigerSBoxes[256:512], tigerSBoxes[512:768], tigerSBoxes[768:1024]
+
+	var x [8]uint64
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+
+	a, b, c := state[0], state[1], state[2]
+
+	round := func(a, b, c *uint64, x, mul uint64) {
+		*c ^= x
+		*a -= sb1[byte(*c)] ^ sb2[byte(*c>>16)] ^ sb3[byte(*c>>32)] ^ sb4[byte(*c>>48)]
+		*b += sb4[byte(*c>>8)] ^ sb3[byte(*c>>24)] ^ sb2[byte(*c>>40)] ^ sb1[byte(*c>>56)]
+		*b *= mul
+	}
+
+	pass := func(a, b, c *uint64, mul uint64) {
+		round(a, b, c, x[0], mul)
+		round(b, c, a, x[1], mul)
+		round(c, a, b, x[2], mul)
+		round(a, b, c, x[3], mul)
+		round(b, c, a, x[4], mul)
+		round(c, a, b, x[5], mul)
+		round(a, b, c, x[6], mul)
+		round(b, c, a, x[7], mul)
+	}
+
+	keySchedule := func() {
+		x[0] -= x[7] ^ 0xA5A5A5A5A5A5A5A5
+		x[1] ^= x[0]
+		x[2] += x[1]
+		x[3] -= x[2] ^ ((^x[1]) << 19)
+		x[4] ^= x[3]
+		x[5] += x[4]
+		x[6] -= x[5] ^ ((^x[4]) >> 23)
+		x[7] ^= x[6]
+		x[0] += x[7]
+		x[1] -= x[0] ^ ((^x[7]) << 19)
+		x[2] ^= x[1]
+		x[3] += x[2]
+		x[4] -= x[3] ^ ((^x[2]) >> 23)
+		x[5] ^= x[4]
+		x[6] += x[5]
+		x[7] -= x[6] ^ 0x0123456789ABCDEF
+	}
+
+	aa, bb, cc := a, b, c
+
+	pass(&a, &b, &c, 5)
+	keySchedule()
+	pass(&c, &a, &b, 7)
+	keySchedule()
+	pass(&b, &c, &a, 9)
+
+	state[0] = a ^ aa
+	state[1] = b - bb
+	state[2] = c + cc
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// test vectors from the Tiger reference implementation (NESSIE)
+var tigerVectors = []struct {
+	input, hash string
+}{
+	{"", "3293ac630c13f0245f92bbb1766e16167a4e58492dde73f3"},
+	{"a", "77befbef2e7ef8ab2ec8f93bf587a7fc613e247f5f247809"},
+	{"abc", "2aab1484e8c158f2bfb8c5ff41b57a525129131c957b5f93"},
+	{"message digest", "d981f8cb78201a950dcf3048751e441c517fca1aa55a29f6"},
+	{"abcdefghijklmnopqrstuvwxyz", "1714a472eee57d30040412bfcc55032a0b11602ff37beee9"},
+	{"abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "0f7bf9a19b9c58f2b7610df7e84f0ac3a71c631e7b53f78e"},
+	{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "8dcea680a17583ee502ba38a3c368651890ffbccdc49a8cc"},
+	{strings.Repeat("a", 1000000), "6db0e2729cbead93d715c6a7d36302e9b3cee0d2bc314b41"},
+}
+
+func TestTiger(t *testing.T) {
+	for _, v := range tigerVectors {
+		hash := tiger([]byte(v.input))
+		if got := hex.EncodeToString(hash[:]); got != v.hash {
+			t.Errorf("Tiger('%.64s'): expected %s, got %s", v.input, v.hash, got)
+		}
+	}
+}
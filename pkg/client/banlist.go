@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/pkg/protocol"
+)
+
+// how long after the last addgban following a cleargbans the new list replaces the old one; the master sends the
+// whole list at once
+const banListSettleTime = time.Second
+
+// BanList is the list of IP ranges banned by the master server. Feed it the messages from the bansInc channel
+// returned by New, using Handle or Consume.
+//
+// When the master sends a new list (cleargbans followed by addgban messages, e.g. after a reconnect), the previous list
+// keeps applying until the new one was received completely, so there is no moment without bans.
+type BanList struct {
+	path   string // where the list is persisted; empty if it isn't
+	settle time.Duration
+
+	current atomic.Pointer[banSet] // what IsBanned checks
+
+	mutex   sync.Mutex
+	active  *banSet     // the last complete list
+	pending *banSet     // the list being received after a cleargbans; nil if none is
+	gen     int         // incremented on every cleargbans, so stale timers can be told apart
+	timer   *time.Timer // swaps pending and active
+}
+
+// NewBanList returns an empty ban list. If path is not empty, the list is loaded from that file if it exists, and
+// saved to it whenever it changes, so the last known bans keep applying while the master server is unreachable.
+func NewBanList(path string) (*BanList, error) {
+	l := &BanList{
+		path:   path,
+		settle: banListSettleTime,
+		active: newBanSet(nil),
+	}
+	if path != "" {
+		ranges, err := loadRanges(path)
+		if err != nil {
+			return nil, err
+		}
+		l.active = newBanSet(ranges)
+	}
+	l.current.Store(l.active)
+	return l, nil
+}
+
+// Consume handles the messages received on bansInc until the channel is closed.
+func (l *BanList) Consume(bansInc <-chan string) {
+	for msg := range bansInc {
+		err := l.Handle(msg)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Handle processes a cleargbans or addgban message.
+func (l *BanList) Handle(msg string) error {
+	cmd, args, _ := strings.Cut(msg, " ")
+	switch cmd {
+	case protocol.ClearBans:
+		l.clear()
+		return nil
+	case protocol.AddBan:
+		r, err := ParseIPRange(strings.TrimSpace(args))
+		if err != nil {
+			return fmt.Errorf("ban list: %s: %w", msg, err)
+		}
+		l.add(r)
+		return nil
+	default:
+		return fmt.Errorf("ban list: unexpected message '%s'", msg)
+	}
+}
+
+// IsBanned reports whether ip is in one of the banned ranges.
+func (l *BanList) IsBanned(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return l.current.Load().contains(addr.Unmap())
+}
+
+// Ranges returns the banned IP ranges.
+func (l *BanList) Ranges() []netip.Prefix {
+	return slices.Clone(l.current.Load().ranges)
+}
+
+func (l *BanList) clear() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.pending != nil {
+		// the previous list may be incomplete, so its bans keep applying until the new one is complete
+		l.active = l.active.union(l.pending)
+	}
+	l.gen++
+	l.pending = newBanSet(nil)
+	l.scheduleSwap()
+}
+
+func (l *BanList) add(r netip.Prefix) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.pending == nil {
+		l.active = l.active.with(r)
+		l.current.Store(l.active)
+		l.save(l.active)
+		return
+	}
+
+	// until the new list is complete, both lists apply
+	l.pending = l.pending.with(r)
+	l.current.Store(l.active.union(l.pending))
+	l.scheduleSwap()
+}
+
+// scheduleSwap (re)starts the timer that makes the pending list the active one. l.mutex must be held.
+func (l *BanList) scheduleSwap() {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	gen := l.gen
+	l.timer = time.AfterFunc(l.settle, func() { l.swap(gen) })
+}
+
+func (l *BanList) swap(gen int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if gen != l.gen || l.pending == nil {
+		return // a newer list is being received
+	}
+	l.active, l.pending = l.pending, nil
+	l.current.Store(l.active)
+	l.save(l.active)
+}
+
+// save writes the list to l.path, if set. l.mutex must be held.
+func (l *BanList) save(s *banSet) {
+	if l.path == "" {
+		return
+	}
+	err := saveRanges(l.path, s.ranges)
+	if err != nil {
+		log.Printf("ban list: %v", err)
+	}
+}
+
+func loadRanges(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ban list: loading %s: %w", path, err)
+	}
+	defer f.Close()
+
+	ranges := []netip.Prefix{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		r, err := ParseIPRange(line)
+		if err != nil {
+			return nil, fmt.Errorf("ban list: loading %s: %w", path, err)
+		}
+		ranges = append(ranges, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ban list: loading %s: %w", path, err)
+	}
+	return ranges, nil
+}
+
+// saveRanges replaces the file at path, so a crash never leaves a partially written list behind.
+func saveRanges(path string, ranges []netip.Prefix) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("saving to %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name()) // fails after the rename
+
+	w := bufio.NewWriter(tmp)
+	for _, r := range ranges {
+		fmt.Fprintln(w, r)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("saving to %s: %w", path, err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("saving to %s: %w", path, err)
+	}
+	return nil
+}
+
+// banSet is an immutable set of IP ranges.
+type banSet struct {
+	ranges []netip.Prefix // in the order they were added
+	masked map[netip.Prefix]struct{}
+	bits   []int // the distinct prefix lengths of IPv4 ranges
+	bits6  []int // the distinct prefix lengths of IPv6 ranges
+}
+
+func newBanSet(ranges []netip.Prefix) *banSet {
+	s := &banSet{
+		ranges: ranges,
+		masked: make(map[netip.Prefix]struct{}, len(ranges)),
+	}
+	for _, r := range ranges {
+		s.masked[r] = struct{}{}
+		if r.Addr().Is4() {
+			if !slices.Contains(s.bits, r.Bits()) {
+				s.bits = append(s.bits, r.Bits())
+			}
+		} else if !slices.Contains(s.bits6, r.Bits()) {
+			s.bits6 = append(s.bits6, r.Bits())
+		}
+	}
+	return s
+}
+
+func (s *banSet) with(r netip.Prefix) *banSet {
+	if _, ok := s.masked[r]; ok {
+		return s
+	}
+	return newBanSet(append(slices.Clip(s.ranges), r))
+}
+
+func (s *banSet) union(o *banSet) *banSet {
+	ranges := slices.Clone(s.ranges)
+	for _, r := range o.ranges {
+		if _, ok := s.masked[r]; !ok {
+			ranges = append(ranges, r)
+		}
+	}
+	return newBanSet(ranges)
+}
+
+func (s *banSet) contains(addr netip.Addr) bool {
+	bits := s.bits
+	if addr.Is6() {
+		bits = s.bits6
+	}
+	for _, b := range bits {
+		p, err := addr.Prefix(b)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.masked[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIPRange parses an IP range the way Sauerbraten's ban commands do: a (partial) IPv4 address like '1.2.3', which
+// means 1.2.3.0/24, optionally followed by a prefix length like '1.2.0.0/12'. IPv6 addresses and ranges in CIDR notation
+// are accepted as well. The returned prefix is masked.
+func ParseIPRange(s string) (netip.Prefix, error) {
+	if strings.Contains(s, ":") {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return netip.Prefix{}, err
+			}
+			s = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() {
+			if p.Bits() < 96 {
+				// also covers IPv6 addresses, so it can't be expressed as an IPv4 range
+				return netip.Prefix{}, fmt.Errorf("'%s' is an IPv4-mapped range shorter than /96", s)
+			}
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+
+	addr, length, hasLength := strings.Cut(s, "/")
+	parts := strings.Split(addr, ".")
+	if len(parts) > 4 {
+		return netip.Prefix{}, fmt.Errorf("'%s' is not an IP address", s)
+	}
+	var octets [4]byte
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("'%s' is not an IP address", s)
+		}
+		octets[i] = byte(n)
+	}
+	bits := 8 * len(parts)
+	if hasLength {
+		var err error
+		bits, err = strconv.Atoi(length)
+		if err != nil || bits < 0 || bits > 32 {
+			return netip.Prefix{}, fmt.Errorf("'%s' has an invalid prefix length", s)
+		}
+	}
+	return netip.PrefixFrom(netip.AddrFrom4(octets), bits).Masked(), nil
+}
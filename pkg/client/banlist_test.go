@@ -0,0 +1,127 @@
+package client
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"1.2.3.4", "1.2.3.4/32"},
+		{"1.2.3", "1.2.3.0/24"},
+		{"1", "1.0.0.0/8"},
+		{"1.2.3.4/16", "1.2.0.0/16"},
+		{"1.2/12", "1.0.0.0/12"},
+		{"0.0.0.0/0", "0.0.0.0/0"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"::ffff:1.2.3.0/120", "1.2.3.0/24"},
+		{"::ffff:0:0/96", "0.0.0.0/0"},
+		{"::ffff:0:0/95", ""},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"1.2.3.256", ""},
+		{"1.2.3.4.5", ""},
+		{"1.2.3.4/33", ""},
+		{"1.2.3.4/", ""},
+		{"1..3", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		p, err := ParseIPRange(test.in)
+		if test.out == "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", test.in, p)
+			}
+			continue
+		}
+		if err != nil || p.String() != test.out {
+			t.Errorf("%s: expected %s, got %s, %v", test.in, test.out, p, err)
+		}
+	}
+}
+
+func expectBanned(t *testing.T, l *BanList, ips map[string]bool) {
+	t.Helper()
+	for ip, expected := range ips {
+		if banned := l.IsBanned(net.ParseIP(ip)); banned != expected {
+			t.Errorf("%s: expected banned = %v, got %v", ip, expected, banned)
+		}
+	}
+}
+
+func handleAll(t *testing.T, l *BanList, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		err := l.Handle(msg)
+		if err != nil {
+			t.Fatalf("handling '%s': %v", msg, err)
+		}
+	}
+}
+
+func TestBanList(t *testing.T) {
+	l, err := NewBanList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.settle = 50 * time.Millisecond
+
+	handleAll(t, l, "cleargbans", "addgban 1.2.3", "addgban 10.0.0.0/8", "addgban 5.6.7.8", "addgban 2001:db8::/32")
+	expectBanned(t, l, map[string]bool{
+		"1.2.3.4":     true,
+		"1.2.4.4":     false,
+		"10.20.30.40": true,
+		"5.6.7.8":     true,
+		"5.6.7.9":     false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	})
+
+	if err := l.Handle("addgban x"); err == nil {
+		t.Error("expected error for malformed addgban")
+	}
+	if err := l.Handle("succreg"); err == nil {
+		t.Error("expected error for unrelated message")
+	}
+
+	// after a reconnect, the old list applies until the new one is complete
+	handleAll(t, l, "cleargbans", "addgban 9.9.9.9")
+	expectBanned(t, l, map[string]bool{"1.2.3.4": true, "9.9.9.9": true})
+
+	time.Sleep(200 * time.Millisecond)
+	expectBanned(t, l, map[string]bool{"1.2.3.4": false, "9.9.9.9": true})
+
+	// bans sent later are added right away
+	handleAll(t, l, "addgban 1.2.3.4")
+	expectBanned(t, l, map[string]bool{"1.2.3.4": true, "1.2.3.5": false, "9.9.9.9": true})
+
+	// an empty list replaces the old one, too
+	handleAll(t, l, "cleargbans")
+	time.Sleep(200 * time.Millisecond)
+	expectBanned(t, l, map[string]bool{"1.2.3.4": false, "9.9.9.9": false})
+}
+
+func TestBanListPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gbans.txt")
+
+	l, err := NewBanList(path)
+	if err != nil {
+		t.Fatalf("creating ban list: %v", err)
+	}
+	l.settle = 10 * time.Millisecond
+	handleAll(t, l, "cleargbans", "addgban 1.2.3", "addgban 2001:db8::/32")
+	time.Sleep(100 * time.Millisecond)
+
+	l, err = NewBanList(path)
+	if err != nil {
+		t.Fatalf("loading ban list: %v", err)
+	}
+	expectBanned(t, l, map[string]bool{"1.2.3.4": true, "2001:db8::1": true, "1.2.4.4": false})
+	if n := len(l.Ranges()); n != 2 {
+		t.Errorf("expected 2 ranges, got %d", n)
+	}
+}
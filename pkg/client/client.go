@@ -152,6 +152,19 @@ func (c *Client) RegisterExtension(cmd string, handler func(args string)) {
 	c.extensions[cmd] = handler
 }
 
+// OnKick registers handler to be called when the master server asks to kick the players authenticated as name, because
+// the account was banned or suspended. reason may be empty.
+func (c *Client) OnKick(handler func(name, reason string)) {
+	c.RegisterExtension(protocol.Kick, func(args string) {
+		name, reason, _ := strings.Cut(args, " ")
+		if name == "" {
+			c.Logf("malformed %s message: '%s'", protocol.Kick, args)
+			return
+		}
+		handler(name, strings.TrimSpace(reason))
+	})
+}
+
 func (c *Client) UnregisterExtension(cmd string) {
 	c.extLock.Lock()
 	defer c.extLock.Unlock()
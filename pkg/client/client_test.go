@@ -0,0 +1,25 @@
+package client
+
+import "testing"
+
+func TestOnKick(t *testing.T) {
+	c, _, _, _ := New("localhost:28787", nil, nil)
+
+	type kick struct{ name, reason string }
+	kicks := []kick{}
+	c.OnKick(func(name, reason string) { kicks = append(kicks, kick{name, reason}) })
+
+	c.Handle("kick alice")
+	c.Handle("kick bob cheating on cool servers")
+	c.Handle("kick ")
+
+	expected := []kick{{"alice", ""}, {"bob", "cheating on cool servers"}}
+	if len(kicks) != len(expected) {
+		t.Fatalf("expected %d kicks, got %v", len(expected), kicks)
+	}
+	for i := range expected {
+		if kicks[i] != expected[i] {
+			t.Errorf("kick %d: expected %v, got %v", i, expected[i], kicks[i])
+		}
+	}
+}
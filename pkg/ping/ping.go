@@ -0,0 +1,88 @@
+// package ping checks that a game server is reachable by sending it the info ping clients use for the server browser.
+//
+// Game servers answer info pings on the UDP port following their game port. The reply starts with the bytes of the
+// request, followed by information about the server (number of players, protocol version, map, ...). A master server
+// pings every game server that registers, so the server list only contains servers clients can actually reach.
+package ping
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Errors returned by Ping.
+var (
+	ErrNoReply      = errors.New("no reply")
+	ErrInvalidReply = errors.New("reply does not echo the request")
+)
+
+// number of times a ping is sent before giving up; UDP packets get lost
+const attempts = 3
+
+// Ping sends an info ping to the game server at addr (the game port, not the info port) and waits up to timeout for a
+// valid reply.
+func Ping(addr netip.AddrPort, timeout time.Duration) error {
+	if addr.Port() == 0xFFFF {
+		return fmt.Errorf("ping: %s has no info port", addr)
+	}
+	infoAddr := netip.AddrPortFrom(addr.Addr(), addr.Port()+1)
+
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(infoAddr))
+	if err != nil {
+		return fmt.Errorf("ping: connecting to %s: %w", infoAddr, err)
+	}
+	defer conn.Close()
+
+	// the game server echoes the request, so a random value tells us the reply is really a reply to this ping
+	req := appendInt(nil, rand.Int32())
+
+	buf := make([]byte, 5000) // replies are a single packet
+	deadline := time.Now().Add(timeout)
+	invalid := false
+	for i := range attempts {
+		_, err = conn.Write(req)
+		if err != nil {
+			return fmt.Errorf("ping: sending to %s: %w", infoAddr, err)
+		}
+
+		// spread the attempts evenly over the timeout
+		conn.SetReadDeadline(time.Now().Add(time.Until(deadline) / time.Duration(attempts-i)))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // timed out, or nothing listening on the port (reported as error by some systems); try again
+			}
+			if validReply(req, buf[:n]) {
+				return nil
+			}
+			invalid = true
+		}
+	}
+
+	if invalid {
+		return fmt.Errorf("ping: %s: %w", infoAddr, ErrInvalidReply)
+	}
+	return fmt.Errorf("ping: %s: %w", infoAddr, ErrNoReply)
+}
+
+// validReply reports whether reply echoes req and contains server info.
+func validReply(req, reply []byte) bool {
+	return len(reply) > len(req) && bytes.HasPrefix(reply, req)
+}
+
+// appendInt appends n in Sauerbraten's compressed integer encoding (putint in shared/tools.cpp).
+func appendInt(b []byte, n int32) []byte {
+	switch {
+	case n < 0x80 && n > -0x7F:
+		return append(b, byte(n))
+	case n < 0x8000 && n >= -0x8000:
+		return append(b, 0x80, byte(n), byte(n>>8))
+	default:
+		return append(b, 0x81, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+}
@@ -0,0 +1,73 @@
+package ping
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sauerbraten/maitred/v2/pkg/ping/pingtest"
+)
+
+func TestAppendInt(t *testing.T) {
+	tests := []struct {
+		n        int32
+		expected []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{127}},
+		{-126, []byte{0x82}},
+		{-127, []byte{0x80, 0x81, 0xFF}},
+		{128, []byte{0x80, 0x80, 0x00}},
+		{260, []byte{0x80, 0x04, 0x01}},
+		{0x8000, []byte{0x81, 0x00, 0x80, 0x00, 0x00}},
+		{-0x8001, []byte{0x81, 0xFF, 0x7F, 0xFF, 0xFF}},
+	}
+
+	for _, test := range tests {
+		b := appendInt(nil, test.n)
+		if string(b) != string(test.expected) {
+			t.Errorf("%d: expected % x, got % x", test.n, test.expected, b)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(req []byte) []byte
+		err   error
+	}{
+		{name: "game server"},
+		{name: "lossy", reply: dropFirst()},
+		{name: "silent", reply: func([]byte) []byte { return nil }, err: ErrNoReply},
+		{name: "wrong cookie", reply: func(req []byte) []byte { return append([]byte{req[0] + 1}, 0, 0) }, err: ErrInvalidReply},
+		{name: "only echo", reply: func(req []byte) []byte { return req }, err: ErrInvalidReply},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := pingtest.NewResponder(test.reply)
+			if err != nil {
+				t.Fatalf("starting responder: %v", err)
+			}
+			defer r.Close()
+
+			err = Ping(r.Addr(), 300*time.Millisecond)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// dropFirst returns a reply function that ignores the first request and answers all others like a game server.
+func dropFirst() func(req []byte) []byte {
+	first := true
+	return func(req []byte) []byte {
+		if first {
+			first = false
+			return nil
+		}
+		return append(req, 0, 1, 0x80, 0x04, 0x01)
+	}
+}
@@ -0,0 +1,62 @@
+// package pingtest provides a fake game server answering info pings, for testing code that pings game servers.
+package pingtest
+
+import (
+	"net"
+	"net/netip"
+)
+
+// info is what the fake game server puts after the echoed request: 0 players, 1 attribute, protocol version 260
+// (encoded like putint in shared/tools.cpp does).
+var info = []byte{0, 1, 0x80, 0x04, 0x01}
+
+// Responder answers info pings on a local UDP port.
+type Responder struct {
+	conn  *net.UDPConn
+	reply func(req []byte) []byte
+}
+
+// NewResponder starts answering info pings on a random local port. Use Addr to get the game server address to ping.
+// If reply is not nil, it is called with each request and returns the reply to send, or nil to not reply. Otherwise,
+// pings are answered like a game server does.
+func NewResponder(reply func(req []byte) []byte) (*Responder, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{conn: conn, reply: reply}
+	go r.serve()
+	return r, nil
+}
+
+// Addr returns the address of the fake game server, i.e. the address before the info port it listens on.
+func (r *Responder) Addr() netip.AddrPort {
+	addr := r.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()-1)
+}
+
+// Close stops answering pings.
+func (r *Responder) Close() error {
+	return r.conn.Close()
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 5000)
+	for {
+		n, from, err := r.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+
+		var reply []byte
+		if r.reply != nil {
+			reply = r.reply(req)
+		} else {
+			reply = append(req, info...)
+		}
+		if reply != nil {
+			r.conn.WriteToUDPAddrPort(reply, from)
+		}
+	}
+}
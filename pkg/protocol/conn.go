@@ -15,6 +15,7 @@ type Conn struct {
 
 	incoming chan string
 	outgoing chan string
+	done     chan struct{}
 
 	onDisconnect func(error)
 }
@@ -29,6 +30,7 @@ func NewConn(onDisconnect func(error)) *Conn {
 
 		incoming: make(chan string),
 		outgoing: make(chan string),
+		done:     make(chan struct{}),
 
 		onDisconnect: onDisconnect,
 	}
@@ -44,9 +46,13 @@ func (c *Conn) Start(tcpConn *net.TCPConn) {
 
 func (c *Conn) Incoming() <-chan string { return c.incoming }
 
+// Done returns a channel that is closed when the connection is closed.
+func (c *Conn) Done() <-chan struct{} { return c.done }
+
 func (c *Conn) disconnect(err error) {
 	c._disconnect.Do(func() {
 		c.TCPConn.Close()
+		close(c.done)
 		c.onDisconnect(err)
 	})
 }
@@ -89,3 +95,9 @@ func (c *Conn) drain() {
 func (c *Conn) Send(format string, args ...interface{}) {
 	c.outgoing <- fmt.Sprintf(format, args...)
 }
+
+// CloseWhenSent closes the connection after all messages passed to Send so far have been written. Send must not be
+// called afterwards.
+func (c *Conn) CloseWhenSent() {
+	close(c.outgoing)
+}
@@ -15,4 +15,13 @@ const (
 	ConfAuth = "confauth"
 	SuccAuth = "succauth"
 	FailAuth = "failauth"
+
+	List      = "list"
+	AddServer = "addserver"
+)
+
+// extensions of the standard protocol
+const (
+	// sent by the master server to make game servers kick players authenticated with a banned account
+	Kick = "kick"
 )